@@ -0,0 +1,12 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"errors"
+)
+
+func isProcessAlive(pid int64) (alive bool, err error) {
+	return false, errors.New("checking of a process's state isn't supported")
+}
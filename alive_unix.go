@@ -0,0 +1,28 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package mc4go
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// isProcessAlive checks the process's existence with the signal 0.
+func isProcessAlive(pid int64) (alive bool, err error) {
+	if pid <= 0 || int64(int(pid)) != pid {
+		return false, fmt.Errorf("incorrect pid: %d", pid)
+	}
+	switch err = syscall.Kill(int(pid), 0); err {
+	case nil, syscall.EPERM: // The process exists, but belongs to another user
+		return true, nil
+	case syscall.ESRCH:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+// Not tested yet!
+package mc4go
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+	errorInvalidParameter          = syscall.Errno(87)
+)
+
+// isProcessAlive checks the exit code of the process.
+func isProcessAlive(pid int64) (alive bool, err error) {
+	if pid <= 0 || int64(uint32(pid)) != pid {
+		return false, fmt.Errorf("incorrect pid: %d", pid)
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		if err == errorInvalidParameter { // No such process
+			return false, nil
+		}
+		return false, err
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err = syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false, err
+	}
+	return code == stillActive, nil
+}
@@ -4,61 +4,39 @@
 package main
 
 import (
+	"bufio"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"runtime"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/anatolygudkov/mc4go"
 	"github.com/anatolygudkov/mc4go/internal/app/cli"
 	"github.com/anatolygudkov/mc4go/internal/app/rest"
 )
 
-type Dump struct {
-	File     string    `json:"file"`
-	Version  int32     `json:"version"`
-	Pid      int64     `json:"pid"`
-	Started  int64     `json:"started"`
-	Statics  []Static  `json:"statics"`
-	Counters []Counter `json:"counters"`
-}
-
 type Statics struct {
-	Statics []Static `json:"statics"`
-}
-
-type Static struct {
-	Label string `json:"label"`
-	Value string `json:"value"`
+	Statics []mc4go.Static `json:"statics"`
 }
 
 type Counters struct {
-	Counters []Counter `json:"counters"`
+	Counters []mc4go.CounterValue `json:"counters"`
 }
 
-type Counter struct {
-	ID    int64  `json:"id"`
-	Label string `json:"label"`
-	Value int64  `json:"value"`
-}
-
-func collectStatics(r *mc4go.Reader) (s []Static) {
+func collectStatics(r *mc4go.Reader) (s []mc4go.Static) {
 	r.ForEachStatic(func(lbl, val string) bool {
-		s = append(s, Static{Label: lbl, Value: val})
+		s = append(s, mc4go.Static{Label: lbl, Value: val})
 		return true
 	})
 	return s
 }
 
-func collectCounters(r *mc4go.Reader) (c []Counter) {
-	r.ForEachCounter(func(id, val int64, lbl string) bool {
-		c = append(c, Counter{ID: id, Value: val, Label: lbl})
-		return true
-	})
-	return c
-}
-
 func answerJSON(res http.ResponseWriter, v interface{}) error {
 	res.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(res).Encode(v)
@@ -81,16 +59,45 @@ func doStarted(values *rest.Values, res http.ResponseWriter, req *http.Request,
 }
 
 func doDump(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader, file string) error {
-	d := new(Dump)
+	d := r.Dump()
 	d.File = file
-	d.Version = r.Version()
-	d.Pid = r.Pid()
-	d.Started = r.StartTime()
-	d.Statics = collectStatics(r)
-	d.Counters = collectCounters(r)
 	return answerJSON(res, d)
 }
 
+// Runtime presents the health of the endpoint's process.
+type Runtime struct {
+	Goroutines   int    `json:"goroutines"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+	LastPauseNs  uint64 `json:"lastPauseNs"`
+}
+
+func collectRuntime() *Runtime {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	rt := &Runtime{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+	if m.NumGC > 0 {
+		rt.LastPauseNs = m.PauseNs[(m.NumGC+255)%256]
+	}
+	return rt
+}
+
+func doRuntime(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
+	return answerJSON(res, collectRuntime())
+}
+
 func doStatic(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
 	l := values.String("label")
 	if l == "" {
@@ -109,45 +116,317 @@ func doStatics(values *rest.Values, res http.ResponseWriter, req *http.Request,
 	return answerJSON(res, s)
 }
 
-func doCounter(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
-	il := values.String("id_label")
+func findCounterID(r *mc4go.Reader, il string) (int64, error) {
 	if il == "" {
-		return errors.New("not id nor label specified")
+		return 0, errors.New("not id nor label specified")
 	}
-	if id, err := strconv.Atoi(il); err == nil {
-		v, err := r.GetCounterValue(int64(id))
-		if err != nil {
-			return err
-		}
-		return answerJSON(res, v)
+	if id, err := strconv.ParseInt(il, 10, 64); err == nil {
+		return id, nil
 	}
-	var v int64
+	var counterID int64
 	found := false
 	r.ForEachCounter(
 		func(id, value int64, label string) bool {
 			if label == il {
-				v = value
+				counterID = id
 				found = true
 				return false
 			}
 			return true
 		})
 	if !found {
-		return fmt.Errorf("no counter with the label '%s' found", il)
+		return 0, fmt.Errorf("no counter with the label '%s' found", il)
+	}
+	return counterID, nil
+}
+
+func doCounter(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
+	id, err := findCounterID(r, values.String("id_label"))
+	if err != nil {
+		return err
+	}
+	counterType, err := r.GetCounterType(id)
+	if err != nil {
+		return err
+	}
+	if counterType == mc4go.CounterTypeFloat64 {
+		v, err := r.GetFloatCounterValue(id)
+		if err != nil {
+			return err
+		}
+		return answerJSON(res, v)
+	}
+	v, err := r.GetCounterValue(id)
+	if err != nil {
+		return err
 	}
 	return answerJSON(res, v)
 }
 
+// CounterUpdate is a body of the request setting a counter's value.
+type CounterUpdate struct {
+	Value int64 `json:"value"`
+}
+
+func doSetCounter(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader, token string) error {
+	if !r.IsWritable() {
+		return answerStatus(res, http.StatusForbidden, "the endpoint is read-only")
+	}
+	if token == "" ||
+		subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		return answerStatus(res, http.StatusUnauthorized, "a valid token is required")
+	}
+	id, err := findCounterID(r, values.String("id_label"))
+	if err != nil {
+		return err
+	}
+	u := new(CounterUpdate)
+	if err := rest.DecodeJSON(req, u); err != nil {
+		return answerStatus(res, http.StatusBadRequest, err.Error())
+	}
+	if err := r.SetCounterValue(id, u.Value); err != nil {
+		return err
+	}
+	return answerJSON(res, u.Value)
+}
+
+func answerStatus(res http.ResponseWriter, code int, msg string) error {
+	res.WriteHeader(code)
+	_, err := fmt.Fprintf(res, "An error: %s", msg)
+	return err
+}
+
 func doCounters(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
-	c := new(Counters)
-	c.Counters = collectCounters(r)
-	return answerJSON(res, c)
+	// The counters are streamed, since there may be lots of them. The answer's shape is Counters
+	res.Header().Set("Content-Type", "application/json")
+	if _, err := io.WriteString(res, `{"counters":`); err != nil {
+		return err
+	}
+	if err := r.StreamCountersJSON(res); err != nil {
+		return err
+	}
+	_, err := io.WriteString(res, "}\n")
+	return err
+}
+
+// liveReader keeps a reader of the file open across recreations of the file by the writer.
+type liveReader struct {
+	file   string
+	open   func(file string) (*mc4go.Reader, error)
+	lock   sync.Mutex // Guards the fields below and the references of the readers
+	r      *sharedReader
+	closed bool
+}
+
+// sharedReader is a reader used by the requests in flight. A replaced reader is closed
+// once the last of them is served.
+type sharedReader struct {
+	r       *mc4go.Reader
+	refs    int
+	retired bool
+}
+
+func newLiveReader(file string, open func(file string) (*mc4go.Reader, error)) (*liveReader, error) {
+	r, err := open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &liveReader{
+		file: file,
+		open: open,
+		r:    &sharedReader{r: r},
+	}, nil
+}
+
+// acquire returns the current reader, which isn't closed until it's released.
+func (l *liveReader) acquire() (s *sharedReader, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil, errors.New("the reader is closed")
+	}
+	l.r.refs++
+	return l.r, nil
+}
+
+// release closes the reader if it's replaced and not used anymore.
+func (l *liveReader) release(s *sharedReader) {
+	l.lock.Lock()
+	s.refs--
+	unused := s.retired && s.refs == 0
+	l.lock.Unlock()
+	if unused {
+		s.r.Close()
+	}
+}
+
+// retire replaces the current reader with the one specified, which is nil on close.
+// The replaced reader is closed right away if it isn't used.
+func (l *liveReader) retire(r *mc4go.Reader) error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		if r != nil {
+			r.Close()
+		}
+		return errors.New("the reader is closed")
+	}
+	old := l.r
+	old.retired = true
+	if r != nil {
+		l.r = &sharedReader{r: r}
+	} else {
+		l.closed = true
+	}
+	unused := old.refs == 0
+	l.lock.Unlock()
+	if unused {
+		return old.r.Close()
+	}
+	return nil
+}
+
+// refresh reopens the file if it's recreated. While the file is absent or cannot be read yet,
+// the current reader keeps serving the original content and the file is tried again on
+// the next refresh. The requests in flight are served with the replaced reader.
+func (l *liveReader) refresh() (reopened bool, err error) {
+	s, err := l.acquire()
+	if err != nil {
+		return false, err
+	}
+	recreated, err := s.r.DetectRecreation()
+	l.release(s)
+	if err != nil || !recreated {
+		return false, err
+	}
+
+	r, err := l.open(l.file)
+	if err != nil {
+		return false, err
+	}
+	if err := l.retire(r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// watch refreshes the reader each interval until the stop channel is closed.
+func (l *liveReader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.refresh() // Failures are expected while the file is recreated, so just retried
+		}
+	}
+}
+
+// readerHandle serves a request with the reader specified.
+type readerHandle func(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error
+
+// handle returns the handle serving the request with the current reader. If the reader
+// is replaced meanwhile, it's closed once the request is served.
+func (l *liveReader) handle(do readerHandle) rest.Handle {
+	return func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
+		s, err := l.acquire()
+		if err != nil {
+			return err
+		}
+		defer l.release(s)
+		return do(values, res, req, s.r)
+	}
+}
+
+// Close closes the reader once the requests in flight are served.
+func (l *liveReader) Close() error {
+	return l.retire(nil)
+}
+
+func doMetrics(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := r.WritePrometheus(res, "mc4go"); err != nil {
+		return err
+	}
+	return writeRuntimeMetrics(res, collectRuntime())
+}
+
+// writeRuntimeMetrics writes the health of the endpoint's process in the Prometheus text
+// exposition format.
+func writeRuntimeMetrics(w io.Writer, rt *Runtime) error {
+	metrics := []struct {
+		name       string
+		metricType string
+		help       string
+		value      float64
+	}{
+		{"mcendpoint_goroutines", "gauge", "Number of goroutines.", float64(rt.Goroutines)},
+		{"mcendpoint_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(rt.HeapAlloc)},
+		{"mcendpoint_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.", float64(rt.HeapInuse)},
+		{"mcendpoint_heap_objects", "gauge", "Number of allocated heap objects.", float64(rt.HeapObjects)},
+		{"mcendpoint_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(rt.Sys)},
+		{"mcendpoint_gc_total", "counter", "Number of completed GC cycles.", float64(rt.NumGC)},
+		{"mcendpoint_gc_pause_seconds_total", "counter", "Total GC pause time.", float64(rt.PauseTotalNs) / 1e9},
+		{"mcendpoint_gc_last_pause_seconds", "gauge", "Pause time of the last GC cycle.", float64(rt.LastPauseNs) / 1e9},
+	}
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.metricType,
+			m.name, strconv.FormatFloat(m.value, 'g', -1, 64))
+	}
+	return bw.Flush()
+}
+
+// newSrv creates the server exposing the reader's content. POST requests modifying
+// the counters are served only if the reader is writable and the token is specified.
+func newSrv(addr string, l *liveReader, token string) (*rest.Srv, error) {
+	srv := rest.NewSrv(addr)
+
+	withFile := func(do func(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader, file string) error) rest.Handle {
+		return l.handle(func(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
+			return do(values, res, req, r, l.file)
+		})
+	}
+
+	routes := []struct {
+		methods []string
+		url     string
+		handle  rest.Handle
+	}{
+		{[]string{http.MethodGet}, "/dump", withFile(doDump)},
+		{[]string{http.MethodGet}, "/file", withFile(doFile)},
+		{[]string{http.MethodGet}, "/version", withFile(doVersion)},
+		{[]string{http.MethodGet}, "/pid", withFile(doPid)},
+		{[]string{http.MethodGet}, "/started", withFile(doStarted)},
+		{[]string{http.MethodGet}, "/runtime", doRuntime},
+		{[]string{http.MethodGet}, "/static/:label", l.handle(doStatic)},
+		{[]string{http.MethodGet}, "/statics", l.handle(doStatics)},
+		{[]string{http.MethodGet}, "/counter/:id_label", l.handle(doCounter)},
+		{[]string{http.MethodPost}, "/counter/:id_label", l.handle(func(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
+			return doSetCounter(values, res, req, r, token)
+		})},
+		{[]string{http.MethodGet}, "/counters", l.handle(doCounters)},
+		{[]string{http.MethodGet}, "/metrics", l.handle(doMetrics)},
+	}
+
+	for _, route := range routes {
+		if err := srv.Handle(route.methods, route.url, route.handle); err != nil {
+			return nil, err
+		}
+	}
+
+	return srv, nil
 }
 
 func main() {
 	a, err := cli.NewApp()
 	cli.ExitIfError(err)
 
+	a.SetVersionFromBuildInfo()
+	cli.ExitIfError(a.EnableConfigDump())
+
 	fileArg, err := a.NewArgumented("file", 'f', "FILE")
 	cli.ExitIfError(err)
 
@@ -159,6 +438,26 @@ func main() {
 	addrArg.SetDescription("Local address to listen to the incoming requests. For example: 192.168.1.12:8000, :8888.")
 	addrArg.SetDefault("127.0.0.1:8888")
 
+	writableFlag, err := a.NewFlag("writable", 'w')
+	cli.ExitIfError(err)
+	writableFlag.SetDescription("Allow setting values of the counters with POST /counter/<ID or LABEL>. Requires a token.")
+
+	tokenArg, err := a.NewArgumented("token", 't', "TOKEN")
+	cli.ExitIfError(err)
+	tokenArg.SetDescription("Token expected in the 'Authorization: Bearer <TOKEN>' header of the modifying requests.")
+
+	refreshArg, err := a.NewLongArgumented("refresh", "INTERVAL")
+	cli.ExitIfError(err)
+	refreshArg.SetDescription("Interval to check whether the file is recreated by a restarted writer and reopen it.")
+	refreshArg.SetDefault("1s")
+	refreshArg.SetValidator(func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err == nil && d <= 0 {
+			return fmt.Errorf("interval must be positive: %s", s)
+		}
+		return err
+	})
+
 	a.AddUsage("--file /dev/shm/jmx_counters.dat", "Exposes content of the /dev/shm/jmx_counters.dat file.")
 
 	a.Start(func(parameters []string) error {
@@ -166,40 +465,30 @@ func main() {
 
 		file, _ := fileArg.String() //Must have value, since required
 
-		r, err := mc4go.NewReaderForFile(file)
-		cli.ExitIfError(err)
-		defer r.Close()
+		token, _ := tokenArg.String()
 
-		srv := rest.NewSrv(addr)
+		refresh, _ := refreshArg.String()          // Must have a value, since has a default one
+		interval, _ := time.ParseDuration(refresh) // Validated while parsing
 
-		srv.Get("/dump", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doDump(values, res, req, r, file)
-		})
-		srv.Get("/file", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doFile(values, res, req, r, file)
-		})
-		srv.Get("/version", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doVersion(values, res, req, r, file)
-		})
-		srv.Get("/pid", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doPid(values, res, req, r, file)
-		})
-		srv.Get("/started", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doStarted(values, res, req, r, file)
-		})
-		srv.Get("/static/:label", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doStatic(values, res, req, r)
-		})
-		srv.Get("/statics", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doStatics(values, res, req, r)
-		})
-		srv.Get("/counter/:id_label", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doCounter(values, res, req, r)
-		})
-		srv.Get("/counters", func(values *rest.Values, res http.ResponseWriter, req *http.Request) error {
-			return doCounters(values, res, req, r)
-		})
+		open := mc4go.NewReaderForFile
+		if writableFlag.IsSet() {
+			if token == "" {
+				return fmt.Errorf("%s requires %s", writableFlag.DescriptiveName(), tokenArg.DescriptiveName())
+			}
+			open = mc4go.NewReaderForFileWritable
+		}
+		l, err := newLiveReader(file, open)
+		cli.ExitIfError(err)
+		defer l.Close()
 
+		stop := make(chan struct{})
+		defer close(stop)
+		go l.watch(interval, stop)
+
+		srv, err := newSrv(addr, l, token)
+		if err != nil {
+			return err
+		}
 		return srv.Start()
 	})
 }
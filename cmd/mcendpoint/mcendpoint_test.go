@@ -0,0 +1,374 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatolygudkov/mc4go"
+	"github.com/anatolygudkov/mc4go/internal/app/rest"
+)
+
+const testToken = "secret"
+
+func newTestWriter(t testing.TB) (w *mc4go.Writer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "goTestMcendpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = mc4go.NewWriterForFile(path.Join(dir, "counters.dat"), map[string]string{"static": "value"}, 10)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return w, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func serve(srv http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	res := httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+	return res
+}
+
+func TestSetCounter(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	if _, err := w.AddCounterWithInitialValue("cnt", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFileWritable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(srv, http.MethodPost, "/counter/cnt", `{"value": 42}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Status %d, expected %d: %s", res.Code, http.StatusOK, res.Body.String())
+	}
+
+	res = serve(srv, http.MethodGet, "/counter/cnt", "")
+	if body := strings.TrimSpace(res.Body.String()); body != "42" {
+		t.Fatalf("Got value %s, expected %s", body, "42")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/counter/cnt", strings.NewReader(`{"value": 1}`))
+	req.Header.Set("Content-Type", "application/json")
+	res = httptest.NewRecorder()
+	srv.ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSetCounterReadOnly(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	if _, err := w.AddCounterWithInitialValue("cnt", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(srv, http.MethodPost, "/counter/cnt", `{"value": 42}`)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusForbidden)
+	}
+
+	res = serve(srv, http.MethodGet, "/counter/cnt", "")
+	if body := strings.TrimSpace(res.Body.String()); body != "10" {
+		t.Fatalf("Got value %s, expected %s", body, "10")
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(srv, http.MethodGet, "/runtime", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusOK)
+	}
+	rt := new(Runtime)
+	if err := json.Unmarshal(res.Body.Bytes(), rt); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", res.Body.String(), err)
+	}
+	if rt.Goroutines <= 0 || rt.Sys == 0 {
+		t.Fatalf("Unexpected runtime state: %s", res.Body.String())
+	}
+}
+
+func TestCounters(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 3} {
+		for i := 0; i < n; i++ {
+			if _, err := w.AddCounterWithInitialValue("cnt", int64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		res := serve(srv, http.MethodGet, "/counters", "")
+		c := new(Counters)
+		if err := json.Unmarshal(res.Body.Bytes(), c); err != nil {
+			t.Fatalf("Invalid JSON %s: %v", res.Body.String(), err)
+		}
+		if len(c.Counters) != n {
+			t.Fatalf("Got %d counters, expected %d: %s", len(c.Counters), n, res.Body.String())
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	if _, err := w.AddCounterWithInitialValue("cnt", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(srv, http.MethodGet, "/metrics", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusOK)
+	}
+	if !strings.Contains(res.Body.String(), "\nmc4go_cnt{id=\"0\",label=\"cnt\"} 10\n") {
+		t.Fatalf("Unexpected metrics: %s", res.Body.String())
+	}
+	for _, series := range []string{"\nmcendpoint_goroutines ", "\nmcendpoint_heap_alloc_bytes ", "\nmcendpoint_gc_total "} {
+		if !strings.Contains(res.Body.String(), series) {
+			t.Fatalf("No%sseries: %s", series, res.Body.String())
+		}
+	}
+}
+
+func TestRecreatedFile(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	if _, err := w.AddCounterWithInitialValue("old", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv, err := newSrv("", l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go l.watch(10*time.Millisecond, stop)
+
+	// The file is absent for a while, so the original content is still served
+	w.Close()
+	os.Remove(w.Filename())
+
+	time.Sleep(50 * time.Millisecond)
+
+	res := serve(srv, http.MethodGet, "/counter/old", "")
+	if body := strings.TrimSpace(res.Body.String()); body != "1" {
+		t.Fatalf("Got value %s, expected %s", body, "1")
+	}
+
+	w, err = mc4go.NewWriterForFile(w.Filename(), nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.AddCounterWithInitialValue("new", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res = serve(srv, http.MethodGet, "/counter/new", "")
+		if body := strings.TrimSpace(res.Body.String()); body == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The new counter isn't served: %s", res.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRefreshDuringRequest(t *testing.T) {
+	w, cleanup := newTestWriter(t)
+	defer cleanup()
+
+	c, err := w.AddCounterWithInitialValue("old", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newLiveReader(w.Filename(), mc4go.NewReaderForFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	done := make(chan error)
+	slow := l.handle(func(values *rest.Values, res http.ResponseWriter, req *http.Request, r *mc4go.Reader) error {
+		close(started)
+		<-unblock
+		if v, err := r.GetCounterValue(c.ID()); err != nil || v != 1 {
+			return fmt.Errorf("got %d (%v), expected 1", v, err)
+		}
+		return nil
+	})
+	go func() {
+		done <- slow(nil, nil, nil)
+	}()
+	<-started
+
+	w.Close()
+	os.Remove(w.Filename())
+	w, err = mc4go.NewWriterForFile(w.Filename(), nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	refreshed := make(chan error)
+	go func() {
+		reopened, err := l.refresh()
+		if err == nil && !reopened {
+			err = errors.New("the file isn't reopened")
+		}
+		refreshed <- err
+	}()
+	select {
+	case err := <-refreshed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Refresh is blocked by the request in flight")
+	}
+
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatalf("The replaced reader isn't usable until the request is served: %v", err)
+	}
+}
+
+func BenchmarkConcurrentCounters(b *testing.B) {
+	w, cleanup := newTestWriter(b)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		if _, err := w.AddCounterWithInitialValue("cnt", int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	r, err := mc4go.NewReaderForFile(w.Filename())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	pool := mc4go.NewReaderPool(r)
+
+	for _, bc := range []struct {
+		name string
+		get  func() *mc4go.Reader
+		put  func(*mc4go.Reader)
+	}{
+		{"shared", func() *mc4go.Reader { return r }, func(*mc4go.Reader) {}},
+		{"pooled", pool.Get, pool.Put},
+	} {
+		bc := bc
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				req := httptest.NewRequest(http.MethodGet, "/counters", nil)
+				for pb.Next() {
+					h := bc.get()
+					res := httptest.NewRecorder()
+					if err := doCounters(nil, res, req, h); err != nil {
+						b.Fatal(err)
+					}
+					bc.put(h)
+				}
+			})
+		})
+	}
+}
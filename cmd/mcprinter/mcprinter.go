@@ -4,49 +4,292 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/anatolygudkov/mc4go"
 	"github.com/anatolygudkov/mc4go/internal/app/cli"
+	"github.com/anatolygudkov/mc4go/internal/format"
 )
 
-func main() {
+// summary contains aggregates of a counters' file. The sum, the min and the max are of the int64 counters only.
+type summary struct {
+	statics  int
+	counters int
+	summed   int
+	sum      int64
+	min      int64
+	max      int64
+}
+
+// valueFormatter returns the function to print values of the counters.
+func valueFormatter(human bool) func(v int64) string {
+	if human {
+		return format.HumanCount
+	}
+	return func(v int64) string {
+		return strconv.FormatInt(v, 10)
+	}
+}
+
+func summarize(r *mc4go.Reader) (s summary) {
+	r.ForEachStatic(func(label, value string) bool {
+		s.statics++
+		return true
+	})
+
+	r.ForEachTypedCounter(func(id int64, counterType mc4go.CounterType, value int64, label string) bool {
+		s.counters++
+		if counterType != mc4go.CounterTypeInt64 {
+			return true
+		}
+		if s.summed == 0 || value < s.min {
+			s.min = value
+		}
+		if s.summed == 0 || value > s.max {
+			s.max = value
+		}
+		s.sum += value
+		s.summed++
+		return true
+	})
+
+	return s
+}
+
+func printHeader(w io.Writer, r *mc4go.Reader) {
+	fmt.Fprintf(w, "version: %d\n", r.Version())
+	fmt.Fprintf(w, "pid: %d\n", r.Pid())
+	fmt.Fprintf(w, "started: %d\n", r.StartTime())
+	fmt.Fprintf(w, "capacity: %d\n", r.FileInfo().MaxCounters)
+}
+
+func printContent(w io.Writer, r *mc4go.Reader, formatValue func(v int64) string) {
+	r.ForEachStatic(func(label, value string) bool {
+		fmt.Fprintf(w, "static: %s=%s\n", label, value)
+		return true
+	})
+
+	r.ForEachTypedCounter(func(id int64, counterType mc4go.CounterType, value int64, label string) bool {
+		v := mc4go.FormatValue(counterType, value)
+		if counterType == mc4go.CounterTypeInt64 {
+			v = formatValue(value)
+		}
+		fmt.Fprintf(w, "counter: %s[%d]=%s\n", label, id, v)
+		return true
+	})
+}
+
+// printKV prints the statics and the counters as static.<label>=<value> and
+// counter.<label>=<value> lines with the labels and the static values escaped by kvEscape.
+func printKV(w io.Writer, r *mc4go.Reader) {
+	r.ForEachStatic(func(label, value string) bool {
+		fmt.Fprintf(w, "static.%s=%s\n", kvEscape(label), kvEscape(value))
+		return true
+	})
+
+	r.ForEachTypedCounter(func(id int64, counterType mc4go.CounterType, value int64, label string) bool {
+		fmt.Fprintf(w, "counter.%s=%s\n", kvEscape(label), mc4go.FormatValue(counterType, value))
+		return true
+	})
+}
+
+// kvEscape percent-encodes all bytes except ASCII letters, digits and the ._-:/ characters,
+// so a line contains the only '=' and no spaces or line breaks.
+func kvEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("._-:/", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+func printSummary(w io.Writer, s summary, formatValue func(v int64) string) {
+	fmt.Fprintf(w, "statics: %d\n", s.statics)
+	fmt.Fprintf(w, "counters: %d\n", s.counters)
+	if s.summed > 0 {
+		fmt.Fprintf(w, "sum: %s\n", formatValue(s.sum))
+		fmt.Fprintf(w, "min: %s\n", formatValue(s.min))
+		fmt.Fprintf(w, "max: %s\n", formatValue(s.max))
+	}
+}
+
+// openReader opens the file. If wait is true, it waits for the file to be created and initialized.
+func openReader(file string, wait bool, timeout time.Duration) (*mc4go.Reader, error) {
+	if wait {
+		return mc4go.NewReaderForFileAwait(file, timeout)
+	}
+	return mc4go.NewReaderForFile(file)
+}
+
+// openOutput opens the file to append the snapshots to. The file is created if it doesn't exist.
+func openOutput(file string) (*os.File, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the output file: %v", err)
+	}
+	return f, nil
+}
+
+// printSnapshot prints a snapshot with the snapshot function. If stamped is true, the snapshot
+// is preceded with a line containing the time specified. The snapshot is flushed to out at once.
+func printSnapshot(out io.Writer, stamped bool, now time.Time, snapshot func(w io.Writer)) error {
+	bw := bufio.NewWriter(out)
+	if stamped {
+		fmt.Fprintf(bw, "=== %s\n", now.Format(time.RFC3339Nano))
+	}
+	snapshot(bw)
+	return bw.Flush()
+}
+
+// watch prints a stamped snapshot each interval. If frames is positive, it returns after
+// printing that many snapshots, otherwise it prints them until an error occurs.
+func watch(out io.Writer, interval time.Duration, frames int, snapshot func(w io.Writer)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		if err := printSnapshot(out, true, time.Now(), snapshot); err != nil {
+			return err
+		}
+		if n == frames {
+			return nil
+		}
+		<-ticker.C
+	}
+}
+
+// newApp registers the options of the command and returns the app and the work to be started with them.
+func newApp() (a *cli.App, work func(parameters []string) error) {
 	a, err := cli.NewApp()
 	cli.ExitIfError(err)
 
+	a.SetVersionFromBuildInfo()
+
 	fileArg, err := a.NewArgumented("file", 'f', "FILE")
 	cli.ExitIfError(err)
 
 	fileArg.SetDescription("Path to a counters' file to be parsed.")
 	fileArg.Require()
 
+	summaryFlag, err := a.NewFlag("summary", 's')
+	cli.ExitIfError(err)
+
+	summaryFlag.SetDescription("Print totals of the statics and the counters instead of listing them.")
+
+	humanFlag, err := a.NewLongFlag("human")
+	cli.ExitIfError(err)
+
+	humanFlag.SetDescription("Print values of the counters in human-readable form, for example, 1.5K or 2.3M.")
+
+	kvFlag, err := a.NewLongFlag("kv")
+	cli.ExitIfError(err)
+
+	kvFlag.SetDescription("Print only static.<LABEL>=<VALUE> and counter.<LABEL>=<VALUE> lines with special characters percent-encoded.")
+
+	waitFlag, err := a.NewLongFlag("wait")
+	cli.ExitIfError(err)
+
+	waitFlag.SetDescription("Wait for the file to be created and initialized by the writer.")
+
+	waitTimeoutArg, err := a.NewLongArgumented("wait-timeout", "DURATION")
+	cli.ExitIfError(err)
+
+	waitTimeoutArg.SetDescription("Max time to wait for the file with --wait. For example: 500ms, 10s, 1m.")
+	waitTimeoutArg.SetDefault("30s")
+	waitTimeoutArg.SetValidator(func(s string) error {
+		_, err := time.ParseDuration(s)
+		return err
+	})
+
+	watchArg, err := a.NewLongArgumented("watch", "INTERVAL")
+	cli.ExitIfError(err)
+
+	watchArg.SetDescription("Print a snapshot each INTERVAL, for example, 1s, until interrupted.")
+	watchArg.SetValidator(func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err == nil && d <= 0 {
+			return fmt.Errorf("interval must be positive: %s", s)
+		}
+		return err
+	})
+
+	outArg, err := a.NewLongArgumented("out", "PATH")
+	cli.ExitIfError(err)
+
+	outArg.SetDescription("Append the snapshots with timestamps to the file instead of printing them to the standard output.")
+
 	a.AddUsage("--file /dev/shm/jmx_counters.dat", "Prints content of the /dev/shm/jmx_counters.dat file.")
+	a.AddUsage("--summary --file /dev/shm/jmx_counters.dat", "Prints totals of the /dev/shm/jmx_counters.dat file.")
+	a.AddUsage("--kv --file /dev/shm/jmx_counters.dat", "Prints content of the /dev/shm/jmx_counters.dat file as key=value lines.")
+	a.AddUsage("--watch 1s --out counters.log --file /dev/shm/jmx_counters.dat", "Appends content of the /dev/shm/jmx_counters.dat file to counters.log each second.")
+	a.AddUsage("--wait --wait-timeout 1m --file /dev/shm/jmx_counters.dat", "Waits up to 1 minute for the file and prints its content.")
 
-	a.Start(func(parameters []string) error {
+	return a, func(parameters []string) error {
 		file, _ := fileArg.String() //Must have value, since required
 
-		fmt.Printf("file: %s\n", file)
+		outFile, toFile := outArg.String()
 
-		r, err := mc4go.NewReaderForFile(file)
+		if !kvFlag.IsSet() && !toFile {
+			fmt.Printf("file: %s\n", file)
+		}
+
+		waitTimeout, _ := waitTimeoutArg.String()     // Must have a value, since has a default one
+		timeout, _ := time.ParseDuration(waitTimeout) // Validated while parsing
+
+		r, err := openReader(file, waitFlag.IsSet(), timeout)
 		if err != nil {
 			return err
 		}
 		defer r.Close()
 
-		fmt.Printf("version: %d\n", r.Version())
-		fmt.Printf("pid: %d\n", r.Pid())
-		fmt.Printf("started: %d\n", r.StartTime())
+		formatValue := valueFormatter(humanFlag.IsSet())
 
-		r.ForEachStatic(func(label, value string) bool {
-			fmt.Printf("static: %s=%s\n", label, value)
-			return true
-		})
+		snapshot := func(w io.Writer) {
+			if kvFlag.IsSet() {
+				printKV(w, r)
+				return
+			}
 
-		r.ForEachCounter(func(id, value int64, label string) bool {
-			fmt.Printf("counter: %s[%d]=%d\n", label, id, value)
-			return true
-		})
+			printHeader(w, r)
 
-		return nil
-	})
+			if summaryFlag.IsSet() {
+				printSummary(w, summarize(r), formatValue)
+				return
+			}
+
+			printContent(w, r, formatValue)
+		}
+
+		var out io.Writer = os.Stdout
+		if toFile {
+			f, err := openOutput(outFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if interval, ok := watchArg.String(); ok {
+			d, _ := time.ParseDuration(interval) // Validated while parsing
+			return watch(out, d, 0, snapshot)
+		}
+
+		return printSnapshot(out, toFile, time.Now(), snapshot)
+	}
+}
+
+func main() {
+	a, work := newApp()
+	a.Start(work)
 }
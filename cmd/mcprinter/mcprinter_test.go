@@ -0,0 +1,281 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatolygudkov/mc4go"
+)
+
+func newTestWriter(t *testing.T, statics map[string]string, values map[string]int64) (w *mc4go.Writer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "goTestMcprinter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = mc4go.NewWriterForFile(path.Join(dir, "counters.dat"), statics, len(values))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	for label, value := range values {
+		if _, err := w.AddCounterWithInitialValue(label, value); err != nil {
+			w.Close()
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	return w, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	w, cleanup := newTestWriter(t,
+		map[string]string{"static1": "value1", "static2": "value2"},
+		map[string]int64{"cnt1": 10, "cnt2": -5, "cnt3": 20})
+	defer cleanup()
+
+	r, err := mc4go.NewReaderForFile(w.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := summarize(r)
+
+	expected := summary{statics: 2, counters: 3, summed: 3, sum: 25, min: -5, max: 20}
+	if s != expected {
+		t.Fatalf("Got summary %+v, expected %+v", s, expected)
+	}
+
+	var sb strings.Builder
+	printSummary(&sb, s, valueFormatter(false))
+
+	expectedOutput := "statics: 2\ncounters: 3\nsum: 25\nmin: -5\nmax: 20\n"
+	if sb.String() != expectedOutput {
+		t.Fatalf("Got output:\n%s\nexpected:\n%s", sb.String(), expectedOutput)
+	}
+
+	sb.Reset()
+	printSummary(&sb, summary{statics: 0, counters: 2, summed: 2, sum: 2500, min: -1000, max: 3500}, valueFormatter(true))
+
+	expectedOutput = "statics: 0\ncounters: 2\nsum: 2.5K\nmin: -1.0K\nmax: 3.5K\n"
+	if sb.String() != expectedOutput {
+		t.Fatalf("Got output:\n%s\nexpected:\n%s", sb.String(), expectedOutput)
+	}
+}
+
+func TestKV(t *testing.T) {
+	w, cleanup := newTestWriter(t,
+		map[string]string{"host": "node-1", "path": "/var/lib/my app"},
+		map[string]int64{"io.read": 10, "cache hits=": -5, "latency[ms]": 20})
+	defer cleanup()
+
+	r, err := mc4go.NewReaderForFile(w.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var sb strings.Builder
+	printKV(&sb, r)
+
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	sort.Strings(lines) // The counters are added in random order
+	expected := []string{
+		"counter.cache%20hits%3D=-5",
+		"counter.io.read=10",
+		"counter.latency%5Bms%5D=20",
+		"static.host=node-1",
+		"static.path=/var/lib/my%20app",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Got lines:\n%s\nexpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestFloatCounters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestMcprinter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := mc4go.NewWriterForFile(path.Join(dir, "counters.dat"), nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.AddCounterWithInitialValue("int", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddFloatCounter("float", 1.5); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mc4go.NewReaderForFile(w.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if s := summarize(r); s != (summary{counters: 2, summed: 1, sum: 3, min: 3, max: 3}) {
+		t.Fatalf("Got summary %+v, expected the float counter skipped", s)
+	}
+
+	var sb strings.Builder
+	printContent(&sb, r, valueFormatter(false))
+	if !strings.Contains(sb.String(), "counter: float[1]=1.5\n") {
+		t.Fatalf("Float isn't printed as a float:\n%s", sb.String())
+	}
+
+	sb.Reset()
+	printKV(&sb, r)
+	if !strings.Contains(sb.String(), "counter.float=1.5\n") {
+		t.Fatalf("Float isn't printed as a float:\n%s", sb.String())
+	}
+}
+
+func TestWatchToFile(t *testing.T) {
+	w, cleanup := newTestWriter(t, map[string]string{}, map[string]int64{"cnt": 1})
+	defer cleanup()
+
+	r, err := mc4go.NewReaderForFile(w.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	dir, err := ioutil.TempDir("", "goTestMcprinter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := openOutput(path.Join(dir, "missing", "out.log")); err == nil {
+		t.Fatal("The directory of the output file doesn't exist")
+	}
+
+	f, err := openOutput(path.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := watch(f, 10*time.Millisecond, 2, func(w io.Writer) { printKV(w, r) }); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Got output:\n%s\nexpected two blocks", b)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		if !strings.HasPrefix(lines[i], "=== ") {
+			t.Fatalf("Got line %q, expected a timestamp", lines[i])
+		}
+		if _, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(lines[i], "=== ")); err != nil {
+			t.Fatal(err)
+		}
+		if lines[i+1] != "counter.cnt=1" {
+			t.Fatalf("Got line %q, expected counter.cnt=1", lines[i+1])
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestMcprinter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "counters.dat")
+
+	if _, err := openReader(file, false, 0); err == nil {
+		t.Fatal("The file doesn't exist yet")
+	}
+	if _, err := openReader(file, true, 50*time.Millisecond); err == nil {
+		t.Fatal("The file doesn't exist yet")
+	}
+
+	created := make(chan *mc4go.Writer, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w, err := mc4go.NewWriterForFile(file, map[string]string{"static": "value"}, 1)
+		if err != nil {
+			t.Error(err)
+		}
+		created <- w
+	}()
+
+	r, err := openReader(file, true, 10*time.Second)
+	if w := <-created; w != nil {
+		defer w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if v, err := r.GetStaticValue("static"); err != nil || v != "value" {
+		t.Fatalf("Got static %s (%v), expected %s", v, err, "value")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	w, cleanup := newTestWriter(t, map[string]string{}, map[string]int64{"cnt": 1})
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "goTestMcprinter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := path.Join(dir, "out.log")
+
+	a, work := newApp()
+
+	var workErr error
+	started := false
+	a.StartWithArgs([]string{"--wait", "--wait-timeout", "1s", "--kv", "--out", out, "--file", w.Filename()},
+		func(parameters []string) error {
+			started = true
+			workErr = work(parameters)
+			return workErr
+		})
+	if !started {
+		t.Fatal("The command line isn't parsed")
+	}
+	if workErr != nil {
+		t.Fatal(workErr)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\ncounter.cnt=1\n") {
+		t.Fatalf("Unexpected output:\n%s", b)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unsafe"
+
+	"github.com/anatolygudkov/mc4go/internal/layout"
+	"github.com/anatolygudkov/mc4go/internal/offheap"
+)
+
+// Dump is a snapshot of a counters' file. Values of min/max counters are their last recorded values.
+type Dump struct {
+	File        string         `json:"file"`
+	Version     int32          `json:"version"`
+	Pid         int64          `json:"pid"`
+	Started     int64          `json:"started"`
+	ValueStride int            `json:"valueStride,omitempty"`
+	Epoch       int64          `json:"epoch,omitempty"`
+	Statics     []Static       `json:"statics"`
+	Counters    []CounterValue `json:"counters"`
+}
+
+// Static is a label and a value of a static.
+type Static struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// CounterValue is an id, a label, a type and a value of a counter. Min, Max and Count are set for min/max counters.
+// The value of a float counter is its IEEE 754 bits, which are written as a float in JSON.
+type CounterValue struct {
+	ID    int64       `json:"id"`
+	Label string      `json:"label"`
+	Type  CounterType `json:"type,omitempty"`
+	Value int64       `json:"value"`
+	Min   int64       `json:"min,omitempty"`
+	Max   int64       `json:"max,omitempty"`
+	Count int64       `json:"count,omitempty"`
+}
+
+// plainCounterValue is CounterValue without the JSON methods.
+type plainCounterValue CounterValue
+
+// MarshalJSON writes the value of a float counter as a float, or as a string if it's NaN or infinite.
+func (c CounterValue) MarshalJSON() ([]byte, error) {
+	if c.Type != CounterTypeFloat64 {
+		return json.Marshal(plainCounterValue(c))
+	}
+	var value interface{} = json.Number(FormatValue(c.Type, c.Value))
+	if f := math.Float64frombits(uint64(c.Value)); math.IsNaN(f) || math.IsInf(f, 0) {
+		value = FormatValue(c.Type, c.Value)
+	}
+	return json.Marshal(struct {
+		plainCounterValue
+		Value interface{} `json:"value"`
+	}{plainCounterValue(c), value})
+}
+
+// UnmarshalJSON reads the value written by MarshalJSON.
+func (c *CounterValue) UnmarshalJSON(b []byte) error {
+	var v struct {
+		plainCounterValue
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = CounterValue(v.plainCounterValue)
+	if len(v.Value) == 0 {
+		return nil
+	}
+	if c.Type != CounterTypeFloat64 {
+		return json.Unmarshal(v.Value, &c.Value)
+	}
+	s := string(v.Value)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value of float counter %d: %s", c.ID, v.Value)
+	}
+	c.Value = int64(math.Float64bits(f))
+	return nil
+}
+
+// Dump returns a snapshot of the counters' file.
+func (r *Reader) Dump() *Dump {
+	d := &Dump{
+		File:        r.filename,
+		Version:     r.Version(),
+		Pid:         r.Pid(),
+		Started:     r.StartTime(),
+		ValueStride: r.decoder.ValueStride(),
+		Epoch:       r.Epoch(),
+	}
+	r.ForEachStatic(func(label, value string) bool {
+		d.Statics = append(d.Statics, Static{Label: label, Value: value})
+		return true
+	})
+	r.decoder.ForEachTypedCounter(func(id int64, counterType CounterType, value int64, label string) bool {
+		d.Counters = append(d.Counters, r.counterValue(id, counterType, value, label))
+		return true
+	})
+	return d
+}
+
+// counterValue returns the counter's value with the min, the max and the count of a min/max counter.
+func (r *Reader) counterValue(id int64, counterType CounterType, value int64, label string) CounterValue {
+	c := CounterValue{ID: id, Label: label, Type: counterType, Value: value}
+	if counterType == CounterTypeMinMax {
+		if _, min, max, count, err := r.decoder.GetCounterMinMax(id); err == nil {
+			c.Min, c.Max, c.Count = min, max, count
+		}
+	}
+	return c
+}
+
+// ExportJSON writes the snapshot of the counters' file returned by Dump as JSON.
+func (r *Reader) ExportJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Dump())
+}
+
+// StreamCountersJSON writes the counters as a JSON array of CounterValue objects.
+// Unlike Dump, it doesn't collect all the counters in memory before writing them.
+func (r *Reader) StreamCountersJSON(w io.Writer) (err error) {
+	var buf bytes.Buffer // a record at a time
+	enc := json.NewEncoder(&buf)
+
+	buf.WriteByte('[')
+	separator := false
+	r.decoder.ForEachTypedCounter(func(id int64, counterType CounterType, value int64, label string) bool {
+		if separator {
+			buf.WriteByte(',')
+		}
+		separator = true
+		if err = enc.Encode(r.counterValue(id, counterType, value, label)); err != nil {
+			return false
+		}
+		_, err = w.Write(buf.Bytes())
+		buf.Reset()
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(']')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// NewReaderFromJSON creates a reader over an in-memory counters' file restored from
+// the JSON written by ExportJSON. The reader isn't writable.
+func NewReaderFromJSON(rd io.Reader) (r *Reader, err error) {
+	d := new(Dump)
+	if err := json.NewDecoder(rd).Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid counters JSON: %v", err)
+	}
+	if err := checkVersion(d.Version, layout.CountersVersion, layout.MaxCountersVersion); err != nil {
+		return nil, err
+	}
+	if len(d.Counters) > MaxPossibleNumberOfCounters {
+		return nil, fmt.Errorf("too many counters: %d", len(d.Counters))
+	}
+
+	valueStride := d.ValueStride
+	if valueStride == 0 {
+		valueStride = layout.DefaultValueStride
+	}
+	if err := layout.ValidateValueStride(valueStride); err != nil {
+		return nil, err
+	}
+
+	statics := make([][2]string, 0, len(d.Statics))
+	for _, s := range d.Statics {
+		statics = append(statics, [2]string{s.Label, s.Value})
+	}
+
+	staticsLength := layout.StaticsLengthOrdered(statics)
+	metadataLength := layout.MetadataLength(len(d.Counters))
+	valuesLength := layout.ValuesLengthWithStride(len(d.Counters), valueStride)
+
+	size := layout.HeaderLength() + staticsLength + metadataLength + valuesLength
+	words := make([]int64, size/8) // 8 bytes aligned, since all the lengths are
+	b := (*[1 << 30]byte)(unsafe.Pointer(&words[0]))[:size:size]
+
+	encoder := layout.NewEncoder(offheap.NewBufferFromSlice(b),
+		staticsLength,
+		metadataLength,
+		valuesLength)
+
+	encoder.SetPid(d.Pid)
+	encoder.SetStartTime(d.Started)
+	encoder.SetEpoch(d.Epoch)
+	if err := encoder.SetStaticsOrdered(statics); err != nil {
+		return nil, err
+	}
+	values := encoder.Layout.CountersValues
+	for _, c := range d.Counters {
+		valueOffset, err := encoder.AddTypedCounter(c.ID, c.Type, c.Value, c.Label)
+		if err != nil {
+			return nil, err
+		}
+		if c.Type == CounterTypeMinMax && c.Count > 0 {
+			values.PutInt64Volatile(valueOffset+layout.ValueMinOffset, c.Min)
+			values.PutInt64Volatile(valueOffset+layout.ValueMaxOffset, c.Max)
+			values.PutInt64Volatile(valueOffset+layout.ValueCountOffset, c.Count)
+		}
+	}
+	encoder.SetVersion(encoder.RequiredVersion())
+
+	r, err = NewReaderForBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	r.filename = d.File
+	return r, nil
+}
@@ -5,16 +5,22 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 )
 
+// develVersion is the version reported by Go for a main module built from sources.
+const develVersion = "(devel)"
+
 // App is the main structure of a command line application.
 type App struct {
-	options  *Options
-	help     *Flag
-	question *Flag
-	usage    *Usage
+	options    *Options
+	help       *Argumented
+	question   *Flag
+	dumpConfig *Flag
+	usage      *Usage
 }
 
 // NewApp creates new instance of the App.
@@ -95,41 +101,76 @@ func (a *App) SetVersion(version string) {
 	a.usage.SetVersion(version)
 }
 
+// SetVersionFromBuildInfo sets the version of the main module the executable was built from.
+// If there is no build info or the module's version is unknown, "(devel)" is used.
+func (a *App) SetVersionFromBuildInfo() {
+	version := develVersion
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		version = bi.Main.Version
+	}
+	a.SetVersion(version)
+}
+
 func (a *App) SetDescription(description string) {
 	a.usage.SetDescription(description)
 }
 
-func (a *App) Start(work func(parameters []string) error) {
-	if a.help == nil {
-		help, _ := a.options.NewFlag("help", 'h')
-		help.SetDescription("This help.")
-		a.help = help
+// GroupRequiredInUsage makes the help list required options in the separate section.
+func (a *App) GroupRequiredInUsage(b bool) {
+	a.usage.GroupRequired(b)
+}
+
+// EnableConfigDump registers the hidden --dump-config flag. If it's set, Start writes
+// the resolved values of all options and their sources and exits without doing the work.
+func (a *App) EnableConfigDump() (err error) {
+	if a.dumpConfig != nil {
+		return nil
 	}
-	if a.question == nil {
-		question, _ := a.options.NewShortFlag('?')
-		question.SetDescription(a.help.Description())
-		a.question = question
+	f, err := a.options.NewLongFlag("dump-config")
+	if err != nil {
+		return err
 	}
+	f.SetDescription("Print the resolved values of the options and exit.")
+	f.Hide()
+	a.dumpConfig = f
+	return nil
+}
 
-	args := os.Args
-	var parameters []string
-	var err error
-	if len(args) > 0 {
-		parameters, err = a.options.Parse(os.Args[1:])
-		if err != nil {
-			if a.help.IsSet() || a.question.IsSet() {
-				a.printHelp()
-				return
-			}
-			os.Stderr.WriteString(fmt.Sprintf("Error: %v\n", err))
-			a.printHelp()
-			return
-		}
+// Start parses the command line arguments of the process and calls work with the remaining parameters
+// as StartWithArgs does.
+func (a *App) Start(work func(parameters []string) error) {
+	var args []string
+	if len(os.Args) > 0 {
+		args = os.Args[1:]
+	}
+	a.StartWithArgs(args, work)
+}
+
+// StartWithArgs parses the arguments specified, which shouldn't start with the name of the executable,
+// and calls work with the remaining parameters. The help is printed instead if requested or the arguments
+// can't be parsed. An error returned by work is written into Stderr.
+func (a *App) StartWithArgs(args []string, work func(parameters []string) error) {
+	a.registerHelp()
 
+	parameters, err := a.options.Parse(args)
+	if err != nil {
 		if a.help.IsSet() || a.question.IsSet() {
 			a.printHelp()
 			return
 		}
+		os.Stderr.WriteString(fmt.Sprintf("Error: %v\n", err))
+		a.printHelp()
+		return
+	}
+
+	if a.help.IsSet() || a.question.IsSet() {
+		a.printHelp()
+		return
+	}
+
+	if a.dumpConfig != nil && a.dumpConfig.IsSet() {
+		ExitIfError(a.writeConfig(os.Stdout))
+		return
 	}
 
 	defer func() {
@@ -148,6 +189,61 @@ func (a *App) Start(work func(parameters []string) error) {
 	}
 }
 
+// registerHelp registers the --help option with an optional topic and the -? flag.
+func (a *App) registerHelp() {
+	if a.help == nil {
+		help, _ := a.options.NewArgumented("help", 'h', "TOPIC")
+		help.SetDescription(fmt.Sprintf("This help. TOPIC selects the section to print: %s, %s or %s.",
+			HelpTopicOptions, HelpTopicExamples, HelpTopicAll))
+		help.SetOptionalArgument(HelpTopicAll)
+		a.help = help
+	}
+	if a.question == nil {
+		question, _ := a.options.NewShortFlag('?')
+		question.SetDescription("This help.")
+		a.question = question
+	}
+}
+
+// writeConfig writes the resolved values of the options except the service ones.
+func (a *App) writeConfig(sw io.StringWriter) error {
+	var items []descriptedItem
+	for _, o := range a.options.allOptions {
+		if o == optionInfo(a.help) || o == optionInfo(a.question) || o == optionInfo(a.dumpConfig) {
+			continue
+		}
+		items = append(items, *newDescriptedItem(o.DescriptiveName(), resolvedValue(o)))
+	}
+	return newDescriptiveTable("Configuration:", items).write(sw, optionsColumnsWidthFactor)
+}
+
+// resolvedValue describes the value of the option after parsing and where it comes from.
+func resolvedValue(o optionInfo) string {
+	switch o := o.(type) {
+	case *Argumented:
+		v, source := o.value()
+		if source == SourceUnset {
+			return SourceUnset.String()
+		}
+		return fmt.Sprintf("%s (%s)", v, source)
+	case *Flag:
+		if !o.IsSet() {
+			return SourceUnset.String()
+		}
+		if o.Negated() {
+			return fmt.Sprintf("negated (%s)", SourceFlag)
+		}
+		return fmt.Sprintf("set (%s)", SourceFlag)
+	default:
+		return ""
+	}
+}
+
+// printHelp writes the sections of the usage selected by the topic of --help.
 func (a *App) printHelp() {
-	a.usage.Write(os.Stdout)
+	topic, _ := a.help.String()
+	if err := a.usage.WriteTopic(os.Stdout, topic); err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("Error: %v\n", err))
+		a.usage.Write(os.Stdout)
+	}
 }
@@ -0,0 +1,200 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetVersionFromBuildInfo(t *testing.T) {
+	a, err := NewNamedApp("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.SetVersionFromBuildInfo()
+
+	if a.usage.version == "" {
+		t.Fatal("Version should be set")
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	a, err := NewNamedApp("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.EnableConfigDump(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := a.NewArgumented("file", 'f', "FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := a.NewLongArgumented("addr", "ADDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.SetDefault(":8888")
+	user, err := a.NewLongArgumented("user", "USER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.SetEnv("GO_TEST_CLI_USER")
+	t.Setenv("GO_TEST_CLI_USER", "admin")
+	token, err := a.NewLongArgumented("token", "TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verbose, err := a.NewFlag("verbose", 'v')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.options.Parse([]string{"--dump-config", "-v", "--file", "a.dat"}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.dumpConfig.IsSet() {
+		t.Fatal("--dump-config should be set")
+	}
+
+	var sb strings.Builder
+	if err := a.writeConfig(&sb); err != nil {
+		t.Fatal(err)
+	}
+	written := sb.String()
+
+	for name, expected := range map[string]string{
+		file.DescriptiveName():    "a.dat (flag)",
+		addr.DescriptiveName():    ":8888 (default)",
+		user.DescriptiveName():    "admin (env)",
+		token.DescriptiveName():   "unset",
+		verbose.DescriptiveName(): "set (flag)",
+	} {
+		found := false
+		for _, line := range strings.Split(written, "\n") {
+			if strings.Contains(line, name) {
+				found = strings.HasSuffix(line, "  "+expected)
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("%s should be %s in the dump: %s", name, expected, written)
+		}
+	}
+	if strings.Contains(written, "dump-config") {
+		t.Fatalf("The service options should not be dumped: %s", written)
+	}
+
+	u, err := NewUsage("test", a.options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.Reset()
+	if err := u.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), "dump-config") {
+		t.Fatalf("--dump-config should be hidden: %s", sb.String())
+	}
+}
+
+func TestHelpTopics(t *testing.T) {
+	a, err := NewNamedApp("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.NewFlag("verbose", 'v'); err != nil {
+		t.Fatal(err)
+	}
+	a.AddUsage("-v", "Verbose output.")
+	a.registerHelp()
+
+	for args, expected := range map[string]string{
+		"--help":          HelpTopicAll,
+		"-h":              HelpTopicAll,
+		"--help=examples": HelpTopicExamples,
+		"-hoptions":       HelpTopicOptions,
+	} {
+		if _, err := a.options.Parse([]string{args}); err != nil {
+			t.Fatal(err)
+		}
+		if topic, _ := a.help.String(); topic != expected {
+			t.Fatalf("Got topic '%s' for %s, expected '%s'", topic, args, expected)
+		}
+	}
+
+	params, err := a.options.Parse([]string{"--help", "examples"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic, _ := a.help.String(); topic != HelpTopicAll || len(params) != 1 {
+		t.Fatalf("Got topic '%s' and parameters %v, the topic can follow '=' only", topic, params)
+	}
+
+	var sb strings.Builder
+	if err := a.usage.WriteTopic(&sb, HelpTopicExamples); err != nil {
+		t.Fatal(err)
+	}
+	if written := sb.String(); !strings.HasPrefix(written, "Usage:") || strings.Contains(written, "Options:") ||
+		strings.Contains(written, "myapp") {
+		t.Fatalf("Only the usages expected: %s", written)
+	}
+
+	sb.Reset()
+	if err := a.usage.WriteTopic(&sb, HelpTopicOptions); err != nil {
+		t.Fatal(err)
+	}
+	if written := sb.String(); !strings.HasPrefix(written, "Options:") || !strings.Contains(written, "--help[=<TOPIC>]") {
+		t.Fatalf("Only the options expected: %s", written)
+	}
+
+	sb.Reset()
+	if err := a.usage.WriteTopic(&sb, ""); err != nil {
+		t.Fatal(err)
+	}
+	if written := sb.String(); !strings.Contains(written, "Usage:") || !strings.Contains(written, "Options:") {
+		t.Fatalf("All sections expected: %s", written)
+	}
+
+	if err := a.usage.WriteTopic(&sb, "unknown"); err == nil {
+		t.Fatal("An error expected for the unknown topic")
+	}
+}
+
+func TestStartWithArgs(t *testing.T) {
+	a, err := NewNamedApp("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verbose, err := a.NewFlag("verbose", 'v')
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := a.NewLongArgumented("name", "NAME")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var params []string
+	called := false
+	a.StartWithArgs([]string{"p1", "-v", "--name", "x", "--", "-p2"}, func(parameters []string) error {
+		called = true
+		params = parameters
+		return nil
+	})
+
+	if !called {
+		t.Fatal("work must be called")
+	}
+	if !reflect.DeepEqual(params, []string{"p1", "-p2"}) {
+		t.Fatalf("Got parameters %v, expected [p1 -p2]", params)
+	}
+	if v, _ := name.String(); !verbose.IsSet() || v != "x" {
+		t.Fatalf("Got verbose %v and name %s, expected set and x", verbose.IsSet(), v)
+	}
+}
@@ -6,9 +6,12 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -17,12 +20,18 @@ const (
 	argumentExpectedState = 1
 )
 
+const negationPrefix = "no-"
+
+const disablingPrefix = '+'
+
 type optionInfo interface {
 	LongName() string
 	ShortName() rune
 	DescriptiveName() string
 	Description() string
 	IsRequired() bool
+	IsHidden() bool
+	option() *Option
 }
 
 // Options allows to define flags and options with arguments in getopt_long style.
@@ -30,8 +39,24 @@ type Options struct {
 	longOptions  map[string]optionInfo
 	shortOptions map[rune]optionInfo
 	allOptions   []optionInfo
-	arguments    map[string]*string // Key is option's descriptive name
+	arguments    map[*Option]*string
+	negated      map[*Option]bool
+	greedy       map[*Option][]string // tokens consumed by greedy options
+	duplicates   DuplicatePolicy
 	parsed       bool
+	collectAll   bool
+	stdin        io.Reader // replaces os.Stdin in tests
+}
+
+// ParseErrors lists all problems found by Parse when CollectAllErrors is on.
+type ParseErrors []error
+
+func (pe ParseErrors) Error() string {
+	msgs := make([]string, len(pe))
+	for i, err := range pe {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
 }
 
 // NewOptions creates a new instance of Options
@@ -40,7 +65,9 @@ func NewOptions() *Options {
 		longOptions:  make(map[string]optionInfo),
 		shortOptions: make(map[rune]optionInfo),
 		allOptions:   make([]optionInfo, 0),
-		arguments:    make(map[string]*string),
+		arguments:    make(map[*Option]*string),
+		negated:      make(map[*Option]bool),
+		greedy:       make(map[*Option][]string),
 		parsed:       false,
 	}
 }
@@ -135,6 +162,100 @@ func (opts *Options) NewArgumented(longName string, shortName rune, argumentName
 	return a, nil
 }
 
+// OptionInfo describes a registered option, for example, for generators of docs or completions.
+type OptionInfo struct {
+	LongName        string
+	ShortName       rune // zero if the option has no short name
+	DescriptiveName string
+	Description     string
+	Required        bool
+	Hidden          bool
+	Flag            bool   // true for a flag, false for an option with an argument
+	ArgumentName    string // empty for a flag
+	Default         string
+	Env             string
+}
+
+// AllOptions returns descriptions of all registered options, including hidden ones, in the order of registration.
+func (opts *Options) AllOptions() []OptionInfo {
+	infos := make([]OptionInfo, 0, len(opts.allOptions))
+	for _, o := range opts.allOptions {
+		info := OptionInfo{
+			LongName:        o.LongName(),
+			ShortName:       o.ShortName(),
+			DescriptiveName: o.DescriptiveName(),
+			Description:     o.Description(),
+			Required:        o.IsRequired(),
+			Hidden:          o.IsHidden(),
+		}
+		switch o := o.(type) {
+		case *Flag:
+			info.Flag = true
+		case *Argumented:
+			info.ArgumentName = o.ArgumentName()
+			info.Default = o.Default()
+			info.Env = o.Env()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// DuplicatePolicy defines how Parse handles an option specified several times.
+type DuplicatePolicy int
+
+const (
+	// PolicyError makes Parse return an error for a duplicated option. It's the default policy.
+	PolicyError DuplicatePolicy = iota
+	// PolicyLastWins makes the last occurrence of an option override the previous ones.
+	PolicyLastWins
+	// PolicyFirstWins makes the first occurrence of an option win, the following ones are ignored.
+	PolicyFirstWins
+)
+
+// SetDuplicatePolicy sets how Parse handles an option specified several times.
+// Both flags and options with an argument respect the policy.
+func (opts *Options) SetDuplicatePolicy(policy DuplicatePolicy) {
+	opts.duplicates = policy
+}
+
+// markFound records the option found in the argument rs according to the duplicate policy.
+// It returns false if the option is found already and the first occurrence wins.
+func (opts *Options) markFound(oi optionInfo, negated bool, rs []rune) (bool, error) {
+	if _, has := opts.arguments[oi.option()]; has {
+		switch opts.duplicates {
+		case PolicyFirstWins:
+			return false, nil
+		case PolicyLastWins:
+		default:
+			return false, fmt.Errorf("option '%s' is duplicated in '%s'", oi.DescriptiveName(), string(rs))
+		}
+	}
+	opts.arguments[oi.option()] = nil
+	if negated {
+		opts.negated[oi.option()] = true
+	} else {
+		delete(opts.negated, oi.option())
+	}
+	return true, nil
+}
+
+// setArgument sets the value of the option unless the value is set already and the first occurrence wins.
+// It returns true if the value is set.
+func (opts *Options) setArgument(a *Argumented, s string) bool {
+	if v := opts.arguments[a.option()]; v != nil && opts.duplicates == PolicyFirstWins {
+		return false
+	}
+	opts.arguments[a.option()] = &s
+	return true
+}
+
+// CollectAllErrors makes Parse continue after an error where possible and return
+// all found problems as ParseErrors. By default Parse returns the first error.
+func (opts *Options) CollectAllErrors(b bool) {
+	opts.collectAll = b
+}
+
 // Parse parses command line arguments to set found flags and options' arguments.
 // It returns remaining program parameters and an error if happened while parsing.
 // Passed args shouldn't start with the name of the executable.
@@ -142,17 +263,56 @@ func (opts *Options) Parse(args []string) (parameters []string, err error) {
 	opts.parsed = true
 
 	if len(opts.arguments) > 0 {
-		opts.arguments = make(map[string]*string)
+		opts.arguments = make(map[*Option]*string)
+	}
+	if len(opts.negated) > 0 {
+		opts.negated = make(map[*Option]bool)
+	}
+	if len(opts.greedy) > 0 {
+		opts.greedy = make(map[*Option][]string)
 	}
 
 	parameters = make([]string, 0, len(args))
 
+	var errs ParseErrors
+	// fail records the error and tells whether parsing should stop
+	fail := func(err error) bool {
+		errs = append(errs, err)
+		return !opts.collectAll
+	}
+	// failAt records the error of the argument with the index specified
+	failAt := func(index int, err error) bool {
+		return fail(fmt.Errorf("arg %d: %v", index+1, err))
+	}
+
 	currentIndex := 0
 
 	state := paramExpectedState
 	var currentOptionToArgument *Argumented = nil
+	optionIndex := 0 // index of the argument of currentOptionToArgument
 Loop:
 	for currentIndex < len(args) {
+		if state == argumentExpectedState && currentOptionToArgument.greedy {
+			end := currentIndex
+			for end < len(args) && strings.TrimSpace(args[end]) != endOfOptions {
+				end++
+			}
+			if end > currentIndex {
+				tokens := append([]string(nil), args[currentIndex:end]...)
+				joined := strings.Join(tokens, " ")
+				if opts.setArgument(currentOptionToArgument, joined) {
+					opts.greedy[currentOptionToArgument.option()] = tokens
+				}
+				currentOptionToArgument = nil
+				state = paramExpectedState
+			}
+			currentIndex = end
+			if currentIndex < len(args) {
+				currentIndex++ // skip '--'
+			}
+			break Loop
+		}
+
 		s := args[currentIndex]
 		s = strings.TrimSpace(s)
 		if s == "" {
@@ -161,35 +321,62 @@ Loop:
 		}
 
 		rs := []rune(s)
-		switch firstChar := rs[0]; firstChar {
-		case '-':
+		if state == paramExpectedState && rs[0] == disablingPrefix && opts.isDisablingCluster(rs) {
+			if err = opts.parseDisabling(rs); err != nil {
+				if failAt(currentIndex, err) {
+					break Loop
+				}
+			}
+			currentIndex++
+			continue
+		}
+
+		isOption := rs[0] == '-' && !(state == argumentExpectedState && s == stdinArgument) // "-" is a valid argument
+
+		switch {
+		case isOption:
 			switch state {
 			case paramExpectedState:
 				if len(rs) == 1 {
-					return nil, errors.New("'-' isn't allowed option")
+					if failAt(currentIndex, errors.New("'-' isn't allowed option")) {
+						break Loop
+					}
+					break
 				}
 				switch secondChar := s[1]; secondChar {
 				case '-':
-					if len(rs) == 2 { // '--' - end of the options
+					if s == endOfOptions {
 						currentIndex++
 						break Loop
 					}
 					if currentOptionToArgument, err = opts.parseLong(rs); err != nil {
-						return nil, err
+						if failAt(currentIndex, err) {
+							break Loop
+						}
 					}
 					if currentOptionToArgument != nil {
 						state = argumentExpectedState
+						optionIndex = currentIndex
 					}
 				default:
 					if currentOptionToArgument, err = opts.parseShort(rs); err != nil {
-						return nil, err
+						if failAt(currentIndex, err) {
+							break Loop
+						}
 					}
 					if currentOptionToArgument != nil {
 						state = argumentExpectedState
+						optionIndex = currentIndex
 					}
 				}
 			case argumentExpectedState:
-				return nil, fmt.Errorf("no argument found for the option: %s", currentOptionToArgument.descriptiveName)
+				if failAt(optionIndex, fmt.Errorf("no argument found for the option: %s", currentOptionToArgument.descriptiveName)) {
+					break Loop
+				}
+				// Parse the same arg as an option
+				currentOptionToArgument = nil
+				state = paramExpectedState
+				continue
 			default:
 				return nil, errors.New("unexpected internal state")
 			}
@@ -198,7 +385,7 @@ Loop:
 			case paramExpectedState:
 				parameters = append(parameters, s)
 			case argumentExpectedState:
-				opts.arguments[currentOptionToArgument.DescriptiveName()] = &s
+				opts.setArgument(currentOptionToArgument, s)
 				currentOptionToArgument = nil
 				state = paramExpectedState
 			default:
@@ -208,15 +395,21 @@ Loop:
 		currentIndex++
 	}
 
+	if len(errs) > 0 && !opts.collectAll {
+		return nil, errs[0]
+	}
+
 	if state == argumentExpectedState {
-		return nil, fmt.Errorf("no required arg found for the option: %s", currentOptionToArgument.longName)
+		if failAt(optionIndex, fmt.Errorf("no required arg found for the option: %s", currentOptionToArgument.descriptiveName)) {
+			return nil, errs[0]
+		}
 	}
 
 	// Validate required options
 	var missedRequires strings.Builder
 	var missed = 0
 	for _, o := range opts.allOptions {
-		if _, has := opts.arguments[o.DescriptiveName()]; !o.IsRequired() || has {
+		if !o.IsRequired() || opts.isProvided(o) {
 			continue
 		}
 		if missedRequires.Len() > 0 {
@@ -230,7 +423,59 @@ Loop:
 		if missed > 1 {
 			opts = fmt.Sprintf("%ss", opts)
 		}
-		return nil, fmt.Errorf("Required %s missed: %s", opts, missedRequires.String())
+		if fail(fmt.Errorf("Required %s missed: %s", opts, missedRequires.String())) {
+			return nil, errs[0]
+		}
+	}
+
+	// Read values from stdin
+	var stdinReader *Argumented
+	for _, o := range opts.allOptions {
+		a, ok := o.(*Argumented)
+		if !ok || !a.stdin {
+			continue
+		}
+		v := opts.arguments[a.option()]
+		if v == nil || *v != stdinArgument {
+			continue
+		}
+		if stdinReader != nil {
+			if fail(fmt.Errorf("options '%s' and '%s' cannot both read stdin",
+				stdinReader.DescriptiveName(), a.DescriptiveName())) {
+				return nil, errs[0]
+			}
+			continue
+		}
+		stdinReader = a
+		value, err := opts.readStdin()
+		if err != nil {
+			if fail(fmt.Errorf("%s: cannot read stdin: %v", a.DescriptiveName(), err)) {
+				return nil, errs[0]
+			}
+			continue
+		}
+		opts.arguments[a.option()] = &value
+	}
+
+	// Validate values
+	for _, o := range opts.allOptions {
+		a, ok := o.(*Argumented)
+		if !ok || a.validator == nil {
+			continue
+		}
+		v, ok := a.String()
+		if !ok {
+			continue
+		}
+		if err := a.validator(v); err != nil {
+			if fail(fmt.Errorf("%s: %v", a.DescriptiveName(), err)) {
+				return nil, errs[0]
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
 	for _, o := range opts.allOptions {
@@ -246,13 +491,120 @@ Loop:
 	return parameters, nil
 }
 
+// endOfOptions is the argument after which all arguments are program parameters.
+const endOfOptions = "--"
+
+// stdinArgument is the argument telling the value of an option should be read from stdin.
+const stdinArgument = "-"
+
+// readStdin reads the whole stdin and returns it without the trailing line break.
+func (opts *Options) readStdin() (string, error) {
+	r := opts.stdin
+	if r == nil {
+		r = os.Stdin
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// isProvided returns true if the option is found while parsing or,
+// for an option with an argument, its value is provided otherwise as allowed.
+func (opts *Options) isProvided(o optionInfo) bool {
+	if a, ok := o.(*Argumented); ok {
+		return a.isSatisfied()
+	}
+	_, has := opts.arguments[o.option()]
+	return has
+}
+
+// ParseString splits the command line respecting single and double quotes and backslash escapes
+// as a shell does and parses the resulting arguments with Parse.
+func (opts *Options) ParseString(line string) (parameters []string, err error) {
+	args, err := splitCommandLine(line)
+	if err != nil {
+		return nil, err
+	}
+	return opts.Parse(args)
+}
+
+// splitCommandLine splits the line into arguments. Inside double quotes a backslash escapes
+// only '"', '\\', '$' and '`', inside single quotes it has no special meaning.
+func splitCommandLine(line string) (args []string, err error) {
+	var arg strings.Builder
+	inArg := false // true if an argument is being collected, even an empty one like ""
+	var quote rune // the opening quote if inside quotes
+
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+				continue
+			}
+			arg.WriteRune(c)
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				if i+1 < len(rs) && strings.ContainsRune("\"\\$`", rs[i+1]) {
+					i++
+					c = rs[i]
+				}
+				arg.WriteRune(c)
+			default:
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\':
+			if i+1 == len(rs) {
+				return nil, errors.New("no character to escape at the end of the line")
+			}
+			i++
+			arg.WriteRune(rs[i])
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c in the line: %s", quote, line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
 func (opts *Options) parseShort(rs []rune) (o *Argumented, err error) {
 	var argument strings.Builder
+	explicit := false // the argument starts with '=', so it may start with a dash, for example, -abc=-5
 
 	for i := 1; i < len(rs); i++ { // We know that 'rs' consists of at least 2 chars
 		c := rs[i]
 
 		if o != nil {
+			if argument.Len() == 0 && c == '=' && !explicit {
+				explicit = true
+				continue
+			}
 			argument.WriteRune(c)
 			continue
 		}
@@ -266,18 +618,16 @@ func (opts *Options) parseShort(rs []rune) (o *Argumented, err error) {
 			return nil, errors.New(msg)
 		}
 
+		if _, err := opts.markFound(nextOption, false, rs); err != nil {
+			return nil, err
+		}
+
 		switch nextOption.(type) {
 		case *Argumented:
 			o = nextOption.(*Argumented)
-			continue
 		default:
 			o = nil
 		}
-
-		if _, has := opts.arguments[nextOption.DescriptiveName()]; has {
-			return nil, fmt.Errorf("option '%s' is duplicated in '%s'", nextOption.DescriptiveName(), string(rs))
-		}
-		opts.arguments[nextOption.DescriptiveName()] = nil
 	}
 
 	if o == nil {
@@ -285,20 +635,136 @@ func (opts *Options) parseShort(rs []rune) (o *Argumented, err error) {
 	}
 
 	if argument.Len() > 0 {
-		s := argument.String()
-		opts.arguments[o.DescriptiveName()] = &s
+		opts.setArgument(o, argument.String())
 		o = nil
 		return o, nil
 	}
+	if explicit {
+		return nil, fmt.Errorf("no argument found after '=' for the option: %s", o.DescriptiveName())
+	}
+	if o.optional {
+		opts.setImplicit(o)
+		return nil, nil
+	}
 
 	return o, nil
 }
 
+// setImplicit sets the implicit value of the option with an optional argument.
+func (opts *Options) setImplicit(a *Argumented) {
+	opts.setArgument(a, a.implicitValue)
+}
+
+// isDisablingCluster returns true if all the characters after '+' are short names
+// of the flags allowing the '+' prefix, otherwise the argument is a parameter.
+func (opts *Options) isDisablingCluster(rs []rune) bool {
+	if len(rs) < 2 {
+		return false
+	}
+	for _, c := range rs[1:] {
+		if f, ok := opts.shortOptions[c].(*Flag); !ok || !f.plusMinus {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts *Options) parseDisabling(rs []rune) (err error) {
+	for _, c := range rs[1:] {
+		if _, err := opts.markFound(opts.shortOptions[c], true, rs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxSuggestionDistance is the max edit distance between an unknown long name and a suggested one.
+const maxSuggestionDistance = 2
+
+// suggestLong returns a hint with the registered visible long name closest to the unknown one.
+// It returns an empty string if there is no name close enough.
+func (opts *Options) suggestLong(name string, negated bool) string {
+	best := ""
+	bestDistance := maxSuggestionDistance + 1
+	for longName, oi := range opts.longOptions {
+		if oi.option().hidden {
+			continue
+		}
+		if negated {
+			if f, ok := oi.(*Flag); !ok || !f.negatable {
+				continue
+			}
+		}
+		d := editDistance(name, longName)
+		if d >= len([]rune(longName)) {
+			continue
+		}
+		if d < bestDistance || (d == bestDistance && longName < best) {
+			best = longName
+			bestDistance = d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	if negated {
+		best = negationPrefix + best
+	}
+	return fmt.Sprintf("; did you mean '--%s'?", best)
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// isLongNameRune returns true if the character can be in a long name. Besides letters and
+// digits, a long name may contain hyphens, dots and underscores except at its start.
+func isLongNameRune(c rune, first bool) bool {
+	if unicode.IsLetter(c) || unicode.IsDigit(c) {
+		return true
+	}
+	return !first && (c == '-' || c == '.' || c == '_')
+}
+
 func (opts *Options) parseLong(rs []rune) (o *Argumented, err error) {
 	var name strings.Builder
 	var argument *strings.Builder = nil
 
-	for i := 2; i < len(rs); i++ {
+	start := 2
+	negated := strings.HasPrefix(string(rs[start:]), negationPrefix)
+	if negated {
+		start += len(negationPrefix)
+	}
+
+	for i := start; i < len(rs); i++ {
 		c := rs[i]
 		if argument != nil {
 			argument.WriteRune(c)
@@ -308,7 +774,7 @@ func (opts *Options) parseLong(rs []rune) (o *Argumented, err error) {
 			argument = new(strings.Builder)
 			continue
 		}
-		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+		if isLongNameRune(c, name.Len() == 0) {
 			name.WriteRune(c)
 			continue
 		}
@@ -318,16 +784,19 @@ func (opts *Options) parseLong(rs []rune) (o *Argumented, err error) {
 	longName := name.String()
 
 	oi, has := opts.longOptions[longName]
+	if negated {
+		if f, ok := oi.(*Flag); !has || !ok || !f.negatable {
+			return nil, fmt.Errorf("unknown option '--%s%s'%s", negationPrefix, longName, opts.suggestLong(longName, true))
+		}
+	}
 	if !has {
-		return nil, fmt.Errorf("unknown option '--%s'", longName)
+		return nil, fmt.Errorf("unknown option '--%s'%s", longName, opts.suggestLong(longName, false))
 	}
 
-	if _, has := opts.arguments[oi.DescriptiveName()]; has {
-		return nil, fmt.Errorf("option '%s' duplicated in '%s'", oi.DescriptiveName(), string(rs))
+	if _, err := opts.markFound(oi, negated, rs); err != nil {
+		return nil, err
 	}
 
-	opts.arguments[oi.DescriptiveName()] = nil
-
 	switch oi.(type) {
 	case *Argumented:
 		o = oi.(*Argumented)
@@ -342,8 +811,10 @@ func (opts *Options) parseLong(rs []rune) (o *Argumented, err error) {
 		if argument.Len() == 0 {
 			return nil, fmt.Errorf("option %s is a flag and cannot have an argument", oi.DescriptiveName())
 		}
-		s := argument.String()
-		opts.arguments[o.DescriptiveName()] = &s
+		opts.setArgument(o, argument.String())
+		o = nil
+	} else if o.optional {
+		opts.setImplicit(o)
 		o = nil
 	}
 
@@ -369,8 +840,14 @@ func (opts *Options) registerOption(oi optionInfo) (err error) {
 	return nil
 }
 
-func (opts *Options) hasOptions() bool {
-	return len(opts.allOptions) > 0
+func (opts *Options) visibleOptions() []optionInfo {
+	visible := make([]optionInfo, 0, len(opts.allOptions))
+	for _, o := range opts.allOptions {
+		if !o.IsHidden() {
+			visible = append(visible, o)
+		}
+	}
+	return visible
 }
 
 // Option presents the contract common for both a flag and an option with an argument.
@@ -381,6 +858,7 @@ type Option struct {
 	descriptiveName string
 	description     string
 	required        bool
+	hidden          bool
 }
 
 // LongName returns the long name of the option.
@@ -418,17 +896,41 @@ func (o *Option) Require() {
 	o.required = true
 }
 
+// IsHidden returns true if the option isn't shown in the usage.
+func (o *Option) IsHidden() bool {
+	return o.hidden
+}
+
+// Hide excludes the option from the usage. The option is still parsed as usual.
+func (o *Option) Hide() {
+	o.hidden = true
+}
+
 // IsSet returns true if the option was recognized as a set one while parsing.
 func (o *Option) IsSet() bool {
-	_, has := o.owner.arguments[o.DescriptiveName()]
+	_, has := o.owner.arguments[o.option()]
 	return has
 }
 
+// option returns the option itself, so the results of parsing are keyed by the option
+// and not by its descriptive name, which may be changed or be the same for different options.
+func (o *Option) option() *Option {
+	return o
+}
+
 func (o *Option) setLongShortNames(longName string, shortName rune) (err error) {
 	if longName == "" &&
 		shortName == 0 {
 		return errors.New("long name or short name should be specified")
 	}
+	for i, c := range []rune(longName) {
+		if !isLongNameRune(c, i == 0) {
+			return fmt.Errorf("wrong character '%c' in the long name: %s", c, longName)
+		}
+	}
+	if strings.HasPrefix(longName, negationPrefix) {
+		return fmt.Errorf("long name cannot start with '%s', which negates flags: %s", negationPrefix, longName)
+	}
 
 	o.longName = longName
 	o.shortName = shortName
@@ -439,6 +941,65 @@ func (o *Option) setLongShortNames(longName string, shortName rune) (err error)
 // Flag presents a flag option.
 type Flag struct {
 	Option
+	negatable bool
+	plusMinus bool
+}
+
+// AllowNegation allows the flag to be set with its long name prefixed with "no-",
+// for example, --no-cache for the flag --cache. The flag must have a long name.
+func (f *Flag) AllowNegation() {
+	if f.longName == "" || f.negatable {
+		return
+	}
+	f.negatable = true
+	f.descriptiveName = strings.Replace(f.descriptiveName,
+		"--"+f.longName, "--["+negationPrefix+"]"+f.longName, 1)
+}
+
+// AllowPlusMinus allows the flag to be disabled with its short name prefixed with '+',
+// for example, +x disables and -x enables the flag x. The flag must have a short name.
+// Arguments starting with '+' are parameters unless they consist of such flags only.
+func (f *Flag) AllowPlusMinus() {
+	if f.shortName == 0 || f.plusMinus {
+		return
+	}
+	f.plusMinus = true
+	f.descriptiveName = strings.Replace(f.descriptiveName,
+		"-"+string(f.shortName), "-|"+string(disablingPrefix)+string(f.shortName), 1)
+}
+
+// Negated returns true if the flag was set with the "no-" prefix or
+// with the '+' prefix while parsing.
+func (f *Flag) Negated() bool {
+	return f.owner.negated[f.option()]
+}
+
+// ValueSource tells where the value of an option with an argument comes from.
+type ValueSource int
+
+const (
+	// SourceUnset means no value is available.
+	SourceUnset ValueSource = iota
+	// SourceFlag means the value is specified in the command line.
+	SourceFlag
+	// SourceEnv means the value is taken from the environment variable of the option.
+	SourceEnv
+	// SourceDefault means the default value of the option is used.
+	SourceDefault
+)
+
+// String returns the name of the source.
+func (s ValueSource) String() string {
+	switch s {
+	case SourceFlag:
+		return "flag"
+	case SourceEnv:
+		return "env"
+	case SourceDefault:
+		return "default"
+	default:
+		return "unset"
+	}
 }
 
 // Argumented presents an option with an argument.
@@ -446,12 +1007,78 @@ type Argumented struct {
 	Option
 	argumentName         string
 	defaultArgumentValue string
+	env                  string
+	validator            func(string) error
+	satisfiedByEnv       bool
+	satisfiedByDefault   bool
+	stdin                bool
+	greedy               bool
+	optional             bool
+	implicitValue        string
 }
 
 // Require makes the option with an argument required.
 func (a *Argumented) Require() {
 	a.Option.Require()
 	a.defaultArgumentValue = ""
+	a.satisfiedByEnv = false
+	a.satisfiedByDefault = false
+}
+
+// RequireUnlessEnv makes the option required unless the environment variable specified
+// provides its value. It sets the environment variable of the option and clears the default value.
+func (a *Argumented) RequireUnlessEnv(name string) {
+	a.Require()
+	a.env = name
+	a.satisfiedByEnv = true
+}
+
+// RequireUnlessDefault makes the option required unless its default value or its environment variable
+// provides the value. Unlike Require, it keeps the default value.
+func (a *Argumented) RequireUnlessDefault() {
+	a.Option.Require()
+	a.satisfiedByEnv = true
+	a.satisfiedByDefault = true
+}
+
+// isSatisfied returns true if the value of the required option is available after parsing.
+func (a *Argumented) isSatisfied() bool {
+	switch a.Source() {
+	case SourceFlag:
+		return true
+	case SourceEnv:
+		return a.satisfiedByEnv
+	case SourceDefault:
+		return a.satisfiedByDefault
+	default:
+		return false
+	}
+}
+
+// AllowStdin makes Parse read the value of the option from stdin if the argument is "-",
+// for example, --input=-. Stdin is read once, so only one option can take its value from it.
+func (a *Argumented) AllowStdin() {
+	a.stdin = true
+}
+
+// Greedy makes the option consume all arguments following it up to '--' or the end
+// of the command line, even if they look like options, for example, --cmd ls -la /tmp.
+// String returns the consumed arguments joined with spaces and Strings returns them as is.
+// Arguments after '--' are program parameters. A value specified with '=' isn't extended.
+func (a *Argumented) Greedy() {
+	a.greedy = true
+}
+
+// SetOptionalArgument allows the option to be specified without an argument, in which case
+// its value is the implicit one. The argument can be specified only after '=', for example,
+// --help=options, or attached to the short name, for example, -hoptions.
+func (a *Argumented) SetOptionalArgument(implicit string) {
+	if !a.optional {
+		a.descriptiveName = strings.Replace(a.descriptiveName,
+			" <"+a.argumentName+">", "[=<"+a.argumentName+">]", -1)
+	}
+	a.optional = true
+	a.implicitValue = implicit
 }
 
 // ArgumentName returns name of the argument of the option.
@@ -461,7 +1088,9 @@ func (a *Argumented) ArgumentName() (s string) {
 
 // SetDefault sets the default value of the option.
 func (a *Argumented) SetDefault(s string) {
-	a.required = false
+	if !a.satisfiedByDefault {
+		a.required = false
+	}
 	a.defaultArgumentValue = s
 }
 
@@ -470,19 +1099,65 @@ func (a *Argumented) Default() (s string) {
 	return a.defaultArgumentValue
 }
 
+// SetEnv sets the name of the environment variable which value is used
+// if the option isn't specified in the command line. The variable takes precedence over the default value.
+func (a *Argumented) SetEnv(name string) {
+	a.env = name
+}
+
+// SetValidator sets the function which checks the value of the option while parsing.
+// An error returned by the validator is returned by Parse prefixed with the option's descriptive name.
+func (a *Argumented) SetValidator(validator func(string) error) {
+	a.validator = validator
+}
+
+// Env returns the name of the environment variable of the option.
+func (a *Argumented) Env() string {
+	return a.env
+}
+
 // String returns a string value of the option if available after parsing. ok is false if no value available.
 func (a *Argumented) String() (s string, ok bool) {
+	s, source := a.value()
+	return s, source != SourceUnset
+}
+
+// Strings returns the arguments consumed by a greedy option if available after parsing.
+// A value of a non-greedy option or a value not specified in the command line is returned
+// as a single element. ok is false if no value available.
+func (a *Argumented) Strings() (ss []string, ok bool) {
+	if tokens, has := a.owner.greedy[a.option()]; has && a.owner.parsed {
+		return tokens, true
+	}
+	s, ok := a.String()
+	if !ok {
+		return nil, ok
+	}
+	return []string{s}, ok
+}
+
+// Source returns where the value of the option comes from after parsing.
+func (a *Argumented) Source() ValueSource {
+	_, source := a.value()
+	return source
+}
+
+func (a *Argumented) value() (s string, source ValueSource) {
 	if !a.owner.parsed {
-		return "", false
+		return "", SourceUnset
+	}
+	if v := a.owner.arguments[a.option()]; v != nil {
+		return *v, SourceFlag
 	}
-	v := a.owner.arguments[a.DescriptiveName()]
-	if v == nil {
-		if a.defaultArgumentValue != "" {
-			return a.defaultArgumentValue, true
+	if a.env != "" {
+		if v := os.Getenv(a.env); v != "" {
+			return v, SourceEnv
 		}
-		return "", false
 	}
-	return *v, true
+	if a.defaultArgumentValue != "" {
+		return a.defaultArgumentValue, SourceDefault
+	}
+	return "", SourceUnset
 }
 
 // Int returns an int value of the option if available after parsing. ok is false if no value available.
@@ -509,6 +1184,20 @@ func (a *Argumented) Bool() (b bool, ok bool) {
 		s == "1", true
 }
 
+// Time returns a time value of the option parsed with the layout if available after parsing.
+// RFC3339 is used if the layout is empty. ok is false if no value available.
+func (a *Argumented) Time(layout string) (t time.Time, ok bool, err error) {
+	s, ok := a.String()
+	if !ok {
+		return time.Time{}, ok, nil
+	}
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	t, err = time.Parse(layout, s)
+	return t, ok, err
+}
+
 // FileInfo returns a FileInfo using string value of the option if available after parsing. ok is false if no value available.
 func (a *Argumented) FileInfo() (f os.FileInfo, ok bool, err error) {
 	s, ok := a.String()
@@ -4,9 +4,14 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNoOptions(t *testing.T) {
@@ -330,3 +335,815 @@ func TestAllOptions(t *testing.T) {
 		t.Fatalf("Parameters aren't parsed correctly: %v", params)
 	}
 }
+
+func TestNegatableFlag(t *testing.T) {
+	opts := NewOptions()
+
+	cache, err := opts.NewLongFlag("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.AllowNegation()
+
+	verbose, err := opts.NewFlag("verbose", 'v')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = opts.Parse([]string{"--cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.IsSet() || cache.Negated() {
+		t.Fatalf("%s should be set and not negated", cache.DescriptiveName())
+	}
+
+	_, err = opts.Parse([]string{"--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.IsSet() || !cache.Negated() {
+		t.Fatalf("%s should be set and negated", cache.DescriptiveName())
+	}
+
+	_, err = opts.Parse([]string{"--no-verbose"})
+	if err == nil {
+		t.Fatal("An error expected")
+	}
+	if !strings.Contains(strings.ToLower(err.Error()), "unknown option '--no-verbose'") {
+		t.Fatalf("--no-verbose should be unknown, got: %v", err)
+	}
+	if verbose.Negated() {
+		t.Fatalf("%s should not be negated", verbose.DescriptiveName())
+	}
+}
+
+func TestPlusMinusFlag(t *testing.T) {
+	opts := NewOptions()
+
+	x, err := opts.NewShortFlag('x')
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.AllowPlusMinus()
+
+	y, err := opts.NewFlag("yy", 'y')
+	if err != nil {
+		t.Fatal(err)
+	}
+	y.AllowPlusMinus()
+
+	v, err := opts.NewShortFlag('v')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := opts.NewShortArgumented('n', "NAME")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.DescriptiveName() != "-|+x" || y.DescriptiveName() != "-|+y  or  --yy" {
+		t.Fatalf("Unexpected descriptive names: %s, %s", x.DescriptiveName(), y.DescriptiveName())
+	}
+
+	params, err := opts.Parse([]string{"-x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.IsSet() || x.Negated() || len(params) != 0 {
+		t.Fatalf("%s should be enabled", x.DescriptiveName())
+	}
+
+	params, err = opts.Parse([]string{"+x", "-v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.IsSet() || !x.Negated() || !v.IsSet() || len(params) != 0 {
+		t.Fatalf("%s should be disabled", x.DescriptiveName())
+	}
+
+	params, err = opts.Parse([]string{"+xy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.Negated() || !y.Negated() || len(params) != 0 {
+		t.Fatalf("Both %s and %s should be disabled", x.DescriptiveName(), y.DescriptiveName())
+	}
+
+	// Not a cluster of the flags allowing '+', so these are parameters
+	params, err = opts.Parse([]string{"+foo", "+v", "+", "+xv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(params, []string{"+foo", "+v", "+", "+xv"}) || x.IsSet() || v.IsSet() {
+		t.Fatalf("Got parameters %v", params)
+	}
+
+	// An argument isn't parsed as an option
+	_, err = opts.Parse([]string{"-n", "+x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := name.String(); s != "+x" || x.IsSet() {
+		t.Fatalf("Got %s, expected +x", s)
+	}
+
+	if _, err = opts.Parse([]string{"-x", "+x"}); err == nil {
+		t.Fatal("An error expected for the duplicated flag")
+	}
+}
+
+func TestSameDescriptiveNames(t *testing.T) {
+	opts := NewOptions()
+
+	x, err := opts.NewShortArgumented('x', "VALUE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := opts.NewShortArgumented('y', "VALUE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := opts.NewShortFlag('f')
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := opts.NewShortFlag('g')
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Display names don't identify options
+	y.descriptiveName = x.descriptiveName
+	g.descriptiveName = f.descriptiveName
+
+	_, err = opts.Parse([]string{"-x", "1", "-y", "2", "-g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := x.String(); s != "1" {
+		t.Fatalf("Got %s, expected 1", s)
+	}
+	if s, _ := y.String(); s != "2" {
+		t.Fatalf("Got %s, expected 2", s)
+	}
+	if f.IsSet() || !g.IsSet() {
+		t.Fatalf("Only -g should be set, got -f: %t, -g: %t", f.IsSet(), g.IsSet())
+	}
+}
+
+func TestRequireUnlessEnv(t *testing.T) {
+	env := "GO_TEST_CLI_TOKEN"
+	t.Setenv(env, "") // restores the variable after the test
+	os.Unsetenv(env)
+
+	opts := NewOptions()
+	token, err := opts.NewLongArgumented("token", "TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.SetDefault("ignored")
+	token.RequireUnlessEnv(env)
+
+	if _, err = opts.Parse([]string{}); err == nil || !strings.Contains(err.Error(), "--token") {
+		t.Fatalf("Missed required option error expected, got: %v", err)
+	}
+
+	t.Setenv(env, "secret")
+
+	if _, err = opts.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := token.String(); s != "secret" || token.Source() != SourceEnv {
+		t.Fatalf("Got %s from %s, expected secret from env", s, token.Source())
+	}
+
+	if _, err = opts.Parse([]string{"--token", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := token.String(); s != "flag" {
+		t.Fatalf("Got %s, expected flag", s)
+	}
+
+	// The environment variable doesn't satisfy a plain required option
+	other, err := opts.NewLongArgumented("other", "OTHER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Require()
+	other.SetEnv(env)
+	if _, err = opts.Parse([]string{}); err == nil || !strings.Contains(err.Error(), "--other") {
+		t.Fatalf("Missed required option error expected, got: %v", err)
+	}
+}
+
+func TestRequireUnlessDefault(t *testing.T) {
+	opts := NewOptions()
+	addr, err := opts.NewLongArgumented("addr", "ADDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.RequireUnlessDefault()
+
+	if _, err = opts.Parse([]string{}); err == nil {
+		t.Fatal("Missed required option error expected")
+	}
+
+	addr.SetDefault(":8888")
+	if !addr.IsRequired() {
+		t.Fatal("The option must stay required")
+	}
+	if _, err = opts.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := addr.String(); s != ":8888" || addr.Source() != SourceDefault {
+		t.Fatalf("Got %s from %s, expected :8888 from default", s, addr.Source())
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	opts := NewOptions()
+	if _, err := opts.NewFlag("verbose", 'v'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opts.NewArgumented("name", 'n', "NAME"); err != nil {
+		t.Fatal(err)
+	}
+
+	for expected, args := range map[string][]string{
+		"arg 3: unknown option '-x'":                                                {"-v", "param", "-x"},
+		"arg 2: unknown option '--xx'":                                              {"param", "--xx"},
+		"arg 4: unknown option '-x' in '-vx'":                                       {"a", "b", "", "-vx"},
+		"arg 2: no argument found for the option: -n <NAME>  or  --name <NAME>":     {"-v", "-n", "-v"},
+		"arg 3: no required arg found for the option: -n <NAME>  or  --name <NAME>": {"a", "b", "--name"},
+	} {
+		_, err := opts.Parse(args)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("Got error '%v' for %v, expected '%s'", err, args, expected)
+		}
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	opts := NewOptions()
+	if _, err := opts.NewFlag("verbose", 'v'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opts.NewArgumented("name", 'n', "NAME"); err != nil {
+		t.Fatal(err)
+	}
+	hidden, err := opts.NewLongFlag("verbosity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden.Hide()
+
+	for args, expected := range map[string]string{
+		"--verbsoe":    "arg 1: unknown option '--verbsoe'; did you mean '--verbose'?",
+		"--nme":        "arg 1: unknown option '--nme'; did you mean '--name'?",
+		"--timeout":    "arg 1: unknown option '--timeout'",
+		"--verbosityy": "arg 1: unknown option '--verbosityy'",
+		"--x":          "arg 1: unknown option '--x'",
+	} {
+		_, err := opts.Parse([]string{args})
+		if err == nil || err.Error() != expected {
+			t.Fatalf("Got error '%v' for %s, expected '%s'", err, args, expected)
+		}
+	}
+
+	if d := editDistance("verbsoe", "verbose"); d != 2 {
+		t.Fatalf("Distance %d, expected 2", d)
+	}
+}
+
+func TestStdinArgument(t *testing.T) {
+	opts := NewOptions()
+	input, err := opts.NewArgumented("input", 'i', "INPUT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.AllowStdin()
+	token, err := opts.NewLongArgumented("token", "TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.AllowStdin()
+	name, err := opts.NewLongArgumented("name", "NAME")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"--input=-", "--name", "-"}, {"-i", "-", "--name=-"}} {
+		opts.stdin = strings.NewReader("piped value\n")
+		if _, err := opts.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := input.String(); !ok || v != "piped value" {
+			t.Fatalf("Got '%s' for %v, expected 'piped value'", v, args)
+		}
+		if v, _ := input.String(); v != "piped value" {
+			t.Fatalf("Got '%s' on the second call, expected 'piped value'", v)
+		}
+		if v, _ := name.String(); v != "-" {
+			t.Fatalf("Got '%s', expected '-' for the option not reading stdin", v)
+		}
+	}
+
+	opts.stdin = strings.NewReader("piped value")
+	_, err = opts.Parse([]string{"--input=-", "--token=-"})
+	if err == nil || !strings.Contains(err.Error(), "cannot both read stdin") {
+		t.Fatalf("An error expected for two options reading stdin, got %v", err)
+	}
+
+	opts.stdin = strings.NewReader("piped value")
+	if _, err := opts.Parse([]string{"--input=a", "--token=-"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := token.String(); v != "piped value" {
+		t.Fatalf("Got '%s', expected 'piped value'", v)
+	}
+}
+
+func TestGreedyArgument(t *testing.T) {
+	opts := NewOptions()
+	verbose, err := opts.NewFlag("verbose", 'v')
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := opts.NewArgumented("cmd", 'c', "COMMAND")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Greedy()
+
+	params, err := opts.Parse([]string{"p1", "-v", "--cmd", "ls", "-la", " /tmp/my dir", "--", "p2", "--cmd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verbose.IsSet() {
+		t.Fatal("verbose expected to be set")
+	}
+	if tokens, ok := cmd.Strings(); !ok || !reflect.DeepEqual(tokens, []string{"ls", "-la", " /tmp/my dir"}) {
+		t.Fatalf("Got tokens %q, expected [ls -la ' /tmp/my dir']", tokens)
+	}
+	if v, _ := cmd.String(); v != "ls -la  /tmp/my dir" {
+		t.Fatalf("Got '%s', expected 'ls -la  /tmp/my dir'", v)
+	}
+	if !reflect.DeepEqual(params, []string{"p1", "p2", "--cmd"}) {
+		t.Fatalf("Got parameters %q, expected [p1 p2 --cmd]", params)
+	}
+
+	params, err = opts.Parse([]string{"-c", "echo", "-v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verbose.IsSet() {
+		t.Fatal("-v expected to be consumed by the greedy option")
+	}
+	if tokens, _ := cmd.Strings(); !reflect.DeepEqual(tokens, []string{"echo", "-v"}) {
+		t.Fatalf("Got tokens %q, expected [echo -v]", tokens)
+	}
+	if len(params) != 0 {
+		t.Fatalf("Got parameters %q, expected none", params)
+	}
+
+	if _, err := opts.Parse([]string{"--cmd", "--", "p"}); err == nil {
+		t.Fatal("An error expected for the greedy option without arguments")
+	}
+
+	if _, err := opts.Parse([]string{"--cmd=ls", "p"}); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := cmd.Strings(); !reflect.DeepEqual(tokens, []string{"ls"}) {
+		t.Fatalf("Got tokens %q, expected [ls]", tokens)
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	opts := NewOptions()
+	level, err := opts.NewArgumented("level", 'l', "LEVEL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := opts.NewLongFlag("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.AllowNegation()
+
+	args := []string{"--level=info", "--cache", "-l", "warn", "--no-cache", "--level", "debug"}
+
+	for _, dup := range [][]string{args, {"-l", "info", "-ldebug"}} {
+		if _, err := opts.Parse(dup); err == nil || !strings.Contains(err.Error(), "is duplicated") {
+			t.Fatalf("An error expected by default for %v, got %v", dup, err)
+		}
+	}
+
+	for policy, expected := range map[DuplicatePolicy]struct {
+		level   string
+		negated bool
+	}{
+		PolicyError:     {},
+		PolicyLastWins:  {"debug", true},
+		PolicyFirstWins: {"info", false},
+	} {
+		opts.SetDuplicatePolicy(policy)
+		_, err := opts.Parse(args)
+		if policy == PolicyError {
+			if err == nil {
+				t.Fatal("An error expected for PolicyError")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := level.String(); v != expected.level {
+			t.Fatalf("Policy %d: got level %s, expected %s", policy, v, expected.level)
+		}
+		if !cache.IsSet() || cache.Negated() != expected.negated {
+			t.Fatalf("Policy %d: got negated %v, expected %v", policy, cache.Negated(), expected.negated)
+		}
+	}
+}
+
+func TestOptionsInventory(t *testing.T) {
+	opts := NewOptions()
+	verbose, err := opts.NewFlag("verbose", 'v')
+	if err != nil {
+		t.Fatal(err)
+	}
+	verbose.SetDescription("Verbose output.")
+	file, err := opts.NewArgumented("file", 'f', "FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.SetDescription("A file.")
+	file.Require()
+	addr, err := opts.NewLongArgumented("addr", "ADDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.SetDefault(":8888")
+	addr.SetEnv("ADDR")
+	quiet, err := opts.NewShortFlag('q')
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet.Hide()
+
+	expected := []OptionInfo{
+		{LongName: "verbose", ShortName: 'v', DescriptiveName: verbose.DescriptiveName(),
+			Description: "Verbose output.", Flag: true},
+		{LongName: "file", ShortName: 'f', DescriptiveName: file.DescriptiveName(),
+			Description: "A file.", Required: true, ArgumentName: "FILE"},
+		{LongName: "addr", DescriptiveName: addr.DescriptiveName(),
+			ArgumentName: "ADDR", Default: ":8888", Env: "ADDR"},
+		{ShortName: 'q', DescriptiveName: quiet.DescriptiveName(), Hidden: true, Flag: true},
+	}
+	if infos := opts.AllOptions(); !reflect.DeepEqual(infos, expected) {
+		t.Fatalf("Got options %+v, expected %+v", infos, expected)
+	}
+}
+
+func TestValueSource(t *testing.T) {
+	opts := NewOptions()
+
+	x, err := opts.NewLongArgumented("xx", "VALUEXX")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Source() != SourceUnset {
+		t.Fatalf("Source %v, expected %v before parsing", x.Source(), SourceUnset)
+	}
+
+	if _, err := opts.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if x.Source() != SourceUnset {
+		t.Fatalf("Source %v, expected %v", x.Source(), SourceUnset)
+	}
+
+	x.SetDefault("DEFAULTXX")
+	if v, ok := x.String(); !ok || v != "DEFAULTXX" || x.Source() != SourceDefault {
+		t.Fatalf("Value %s from %v, expected %s from %v", v, x.Source(), "DEFAULTXX", SourceDefault)
+	}
+
+	env := "MC4GO_TEST_VALUE_SOURCE"
+	t.Setenv(env, "ENVXX")
+	x.SetEnv(env)
+	if v, ok := x.String(); !ok || v != "ENVXX" || x.Source() != SourceEnv {
+		t.Fatalf("Value %s from %v, expected %s from %v", v, x.Source(), "ENVXX", SourceEnv)
+	}
+
+	if _, err := opts.Parse([]string{"--xx", "FLAGXX"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := x.String(); !ok || v != "FLAGXX" || x.Source() != SourceFlag {
+		t.Fatalf("Value %s from %v, expected %s from %v", v, x.Source(), "FLAGXX", SourceFlag)
+	}
+}
+
+func TestCollectAllErrors(t *testing.T) {
+	opts := NewOptions()
+
+	xx, err := opts.NewLongFlag("xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xx.Require()
+
+	args := []string{"-q", "--zz", "param1"}
+
+	_, err = opts.Parse(args)
+	if err == nil {
+		t.Fatal("An error expected")
+	}
+	if _, ok := err.(ParseErrors); ok {
+		t.Fatalf("The first error only expected, got: %v", err)
+	}
+
+	opts.CollectAllErrors(true)
+
+	params, err := opts.Parse(args)
+	if err == nil {
+		t.Fatal("An error expected")
+	}
+	if params != nil {
+		t.Fatalf("No parameters expected, got: %v", params)
+	}
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("3 errors expected, got: %v", err)
+	}
+	msg := strings.ToLower(err.Error())
+	for _, expected := range []string{"unknown option '-q'", "unknown option '--zz'", "required option missed: '--xx'"} {
+		if !strings.Contains(msg, expected) {
+			t.Fatalf("'%s' should be reported, got: %s", expected, msg)
+		}
+	}
+}
+
+func TestTimeArgument(t *testing.T) {
+	opts := NewOptions()
+
+	at, err := opts.NewLongArgumented("at", "TIME")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := opts.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := at.Time(""); ok || err != nil {
+		t.Fatalf("%s should not be set, got error: %v", at.DescriptiveName(), err)
+	}
+
+	if _, err := opts.Parse([]string{"--at", "2020-05-17T10:20:30Z"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2020, 5, 17, 10, 20, 30, 0, time.UTC)
+	if v, ok, err := at.Time(""); !ok || err != nil || !v.Equal(expected) {
+		t.Fatalf("%s should be %v, got %v (%v)", at.DescriptiveName(), expected, v, err)
+	}
+
+	if _, err := opts.Parse([]string{"--at", "2020-05-17"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)
+	if v, ok, err := at.Time("2006-01-02"); !ok || err != nil || !v.Equal(expected) {
+		t.Fatalf("%s should be %v, got %v (%v)", at.DescriptiveName(), expected, v, err)
+	}
+
+	if _, err := opts.Parse([]string{"--at", "yesterday"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := at.Time(""); !ok || err == nil {
+		t.Fatalf("%s should be set, but not parsed", at.DescriptiveName())
+	}
+}
+
+func TestValidator(t *testing.T) {
+	opts := NewOptions()
+
+	port, err := opts.NewArgumented("port", 'p', "PORT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Require()
+	port.SetValidator(func(s string) error {
+		if _, err := strconv.Atoi(s); err != nil {
+			return errors.New("not a number")
+		}
+		return nil
+	})
+
+	if _, err := opts.Parse([]string{"-p", "8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := port.String(); !ok || v != "8080" {
+		t.Fatalf("%s should be set to %s", port.DescriptiveName(), "8080")
+	}
+
+	_, err = opts.Parse([]string{"--port", "http"})
+	if err == nil {
+		t.Fatal("An error expected")
+	}
+	if expected := port.DescriptiveName() + ": not a number"; err.Error() != expected {
+		t.Fatalf("Error '%v', expected '%s'", err, expected)
+	}
+
+	_, err = opts.Parse([]string{})
+	if err == nil || !strings.Contains(err.Error(), "Required option missed") {
+		t.Fatalf("The required option should be reported, got: %v", err)
+	}
+}
+
+func TestShortClusterWithEquals(t *testing.T) {
+	opts := NewOptions()
+
+	a, err := opts.NewShortFlag('a')
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := opts.NewShortFlag('b')
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := opts.NewShortArgumented('c', "VALUEC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for args, expected := range map[string]string{
+		"-abc=-5":    "-5",
+		"-abcvalue":  "value",
+		"-abc==5":    "=5",
+		"-abc=x=y":   "x=y",
+		"-bac=value": "value",
+	} {
+		params, err := opts.Parse([]string{args, "param"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.IsSet() || !b.IsSet() {
+			t.Fatalf("%s and %s should be set by %s", a.DescriptiveName(), b.DescriptiveName(), args)
+		}
+		if v, ok := c.String(); !ok || v != expected {
+			t.Fatalf("%s should be set to %s by %s, got %s", c.DescriptiveName(), expected, args, v)
+		}
+		if len(params) != 1 || params[0] != "param" {
+			t.Fatalf("Parameters aren't parsed correctly: %v", params)
+		}
+	}
+
+	if _, err := opts.Parse([]string{"-abc=", "param"}); err == nil {
+		t.Fatal("An error expected")
+	}
+}
+
+func TestParseString(t *testing.T) {
+	newOptions := func() (opts *Options, name *Argumented, verbose *Flag) {
+		opts = NewOptions()
+		name, err := opts.NewArgumented("name", 'n', "NAME")
+		if err != nil {
+			t.Fatal(err)
+		}
+		verbose, err = opts.NewFlag("verbose", 'v')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return opts, name, verbose
+	}
+
+	for line, expected := range map[string][]string{
+		`-v --name "John Smith" param`:       {"-v", "--name", "John Smith", "param"},
+		`-v --name 'John Smith' param`:       {"-v", "--name", "John Smith", "param"},
+		`-v --name John\ Smith param`:        {"-v", "--name", "John Smith", "param"},
+		`-v --name "say \"hi\"" param`:       {"-v", "--name", `say "hi"`, "param"},
+		`-v --name 'C:\dir' param`:           {"-v", "--name", `C:\dir`, "param"},
+		`-v   --name=it\'s  "param"`:         {"-v", "--name=it's", "param"},
+		`-v --name "a"'b'c  "param with  x"`: {"-v", "--name", "abc", "param with  x"},
+	} {
+		args, err := splitCommandLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Fatalf("Line %s is split into %q, expected %q", line, args, expected)
+		}
+
+		opts, name, verbose := newOptions()
+		params, err := opts.ParseString(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stringName, _ := name.String()
+
+		opts, name, verbose2 := newOptions()
+		expectedParams, err := opts.Parse(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedName, _ := name.String()
+
+		if !reflect.DeepEqual(params, expectedParams) || stringName != expectedName || verbose.IsSet() != verbose2.IsSet() {
+			t.Fatalf("Line %s is parsed into %q, %s, expected %q, %s", line, params, stringName, expectedParams, expectedName)
+		}
+	}
+
+	for _, line := range []string{`--name "John`, `--name 'John`, `--name John\`} {
+		if _, err := NewOptions().ParseString(line); err == nil {
+			t.Fatalf("An error expected for the line: %s", line)
+		}
+	}
+}
+
+func TestHyphenatedLongNames(t *testing.T) {
+	opts := NewOptions()
+
+	dryRun, err := opts.NewLongArgumented("dry-run", "MODE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, err := opts.NewLongArgumented("wait-timeout", "DURATION")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := opts.NewLongFlag("use-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.AllowNegation()
+
+	if _, err := opts.Parse([]string{"--dry-run=value", "--wait-timeout", "1s", "--no-use-cache"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := dryRun.String(); !ok || v != "value" {
+		t.Fatalf("Got %s, expected %s", v, "value")
+	}
+	if v, ok := timeout.String(); !ok || v != "1s" {
+		t.Fatalf("Got %s, expected %s", v, "1s")
+	}
+	if !cache.IsSet() || !cache.Negated() {
+		t.Fatalf("%s should be negated", cache.DescriptiveName())
+	}
+
+	for _, args := range [][]string{{"--dry-"}, {"--dry-runs=value"}, {"--no-dry-run=value"}} {
+		if _, err := opts.Parse(args); err == nil {
+			t.Fatalf("%v accepted", args)
+		}
+	}
+}
+
+func TestPunctuatedLongNames(t *testing.T) {
+	opts := NewOptions()
+
+	dryRun, err := opts.NewLongArgumented("dry-run", "MODE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := opts.NewFlag("single-y", 'y')
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, err := opts.NewLongArgumented("log.level", "LEVEL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := opts.NewLongFlag("no_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := opts.Parse([]string{"--dry-run=full", "--single-y", "--log.level", "debug", "--no_cache", "param"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 1 || params[0] != "param" {
+		t.Fatalf("Unexpected parameters: %v", params)
+	}
+	if v, ok := dryRun.String(); !ok || v != "full" {
+		t.Fatalf("Got %s, expected %s", v, "full")
+	}
+	if !single.IsSet() {
+		t.Fatalf("%s isn't set", single.DescriptiveName())
+	}
+	if v, ok := level.String(); !ok || v != "debug" {
+		t.Fatalf("Got %s, expected %s", v, "debug")
+	}
+	if !cache.IsSet() {
+		t.Fatalf("%s isn't set", cache.DescriptiveName())
+	}
+
+	for _, name := range []string{"-dash", ".dot", "with space", "a=b", "no-color"} {
+		if _, err := opts.NewLongFlag(name); err == nil {
+			t.Fatalf("Long name '%s' accepted", name)
+		}
+	}
+
+	if _, err := opts.Parse([]string{"--dry+run"}); err == nil {
+		t.Fatal("Wrong character in a long name accepted")
+	}
+}
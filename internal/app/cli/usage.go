@@ -35,9 +35,10 @@ type Usage struct {
 	command string
 	options *Options
 
-	usages      []descriptedItem
-	version     string
-	description string
+	usages        []descriptedItem
+	version       string
+	description   string
+	groupRequired bool
 }
 
 // NewUsage creates new instance of Usage with specified name and options.
@@ -75,8 +76,51 @@ func (u *Usage) SetDescription(description string) {
 	u.description = description
 }
 
+// GroupRequired makes the usage list required options in the separate "Required:" section
+// before the "Options:" section. By default all options are listed together.
+func (u *Usage) GroupRequired(b bool) {
+	u.groupRequired = b
+}
+
+// Help topics selecting sections of the usage.
+const (
+	// HelpTopicAll selects all sections.
+	HelpTopicAll = "all"
+	// HelpTopicOptions selects the options.
+	HelpTopicOptions = "options"
+	// HelpTopicExamples selects the examples of usage.
+	HelpTopicExamples = "examples"
+)
+
 // Write writes formatted usage info into io.StringWriter.
 func (u *Usage) Write(sw io.StringWriter) error {
+	if err := u.writeTitle(sw); err != nil {
+		return err
+	}
+	if err := u.writeExamples(sw); err != nil {
+		return err
+	}
+	return u.writeOptions(sw)
+}
+
+// WriteTopic writes the sections of the usage selected by the topic, which is one of HelpTopic*.
+// An empty topic selects all sections.
+func (u *Usage) WriteTopic(sw io.StringWriter, topic string) error {
+	switch topic {
+	case "", HelpTopicAll:
+		return u.Write(sw)
+	case HelpTopicOptions:
+		return u.writeOptions(sw)
+	case HelpTopicExamples:
+		return u.writeExamples(sw)
+	default:
+		return fmt.Errorf("unknown help topic '%s', expected one of: %s, %s, %s",
+			topic, HelpTopicAll, HelpTopicOptions, HelpTopicExamples)
+	}
+}
+
+// writeTitle writes the name, the version and the description of the application.
+func (u *Usage) writeTitle(sw io.StringWriter) error {
 	if _, err := sw.WriteString(u.name); err != nil {
 		return err
 	}
@@ -106,6 +150,11 @@ func (u *Usage) Write(sw io.StringWriter) error {
 		}
 	}
 
+	return nil
+}
+
+// writeExamples writes the examples of usage.
+func (u *Usage) writeExamples(sw io.StringWriter) error {
 	if len(u.usages) > 0 {
 		dt := newDescriptiveTable("Usage:", u.usages)
 		if err := dt.write(sw, usageColumnsWidthFactor); err != nil {
@@ -113,22 +162,29 @@ func (u *Usage) Write(sw io.StringWriter) error {
 		}
 	}
 
-	if u.options.hasOptions() {
-		options := make([]descriptedItem, len(u.options.allOptions))
-		for i, o := range u.options.allOptions {
-			desc := o.Description()
-			switch o.(type) {
-			case *Argumented:
-				ao := o.(*Argumented)
-				def := ao.Default()
-				if def != "" {
-					desc = fmt.Sprintf("%s Default: %s.", desc, def)
-				}
-			}
-			options[i] = *newDescriptedItem(o.DescriptiveName(), desc)
+	return nil
+}
+
+// writeOptions writes the visible options.
+func (u *Usage) writeOptions(sw io.StringWriter) error {
+	var required, optional []descriptedItem
+	for _, o := range u.options.visibleOptions() {
+		if u.groupRequired && o.IsRequired() {
+			required = append(required, optionItem(o))
+		} else {
+			optional = append(optional, optionItem(o))
 		}
+	}
 
-		dt := newDescriptiveTable("Options:", options)
+	if len(required) > 0 {
+		dt := newDescriptiveTable("Required:", required)
+		if err := dt.write(sw, optionsColumnsWidthFactor); err != nil {
+			return err
+		}
+	}
+
+	if len(optional) > 0 {
+		dt := newDescriptiveTable("Options:", optional)
 		if err := dt.write(sw, optionsColumnsWidthFactor); err != nil {
 			return err
 		}
@@ -137,6 +193,28 @@ func (u *Usage) Write(sw io.StringWriter) error {
 	return nil
 }
 
+// optionItem presents the option in the usage. An option with a default value is shown
+// in brackets, since it can be omitted, while its argument is still mandatory.
+func optionItem(o optionInfo) descriptedItem {
+	name := o.DescriptiveName()
+	desc := o.Description()
+	switch o.(type) {
+	case *Argumented:
+		ao := o.(*Argumented)
+		if env := ao.Env(); env != "" {
+			desc = fmt.Sprintf("%s Env: %s.", desc, env)
+		}
+		def := ao.Default()
+		if def != "" {
+			desc = fmt.Sprintf("%s Default: %s.", desc, def)
+			if !ao.optional {
+				name = "[" + name + "]"
+			}
+		}
+	}
+	return *newDescriptedItem(name, desc)
+}
+
 type descriptedItem struct {
 	item        string
 	description string
@@ -3,8 +3,142 @@
 // that can be found in the LICENSE file.
 package cli
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestWordWrapper(t *testing.T) {
 	//TBD
 }
+
+func TestHiddenOption(t *testing.T) {
+	opts := NewOptions()
+
+	visible, err := opts.NewLongFlag("visible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden, err := opts.NewLongFlag("hidden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden.Hide()
+
+	if _, err := opts.Parse([]string{"--hidden"}); err != nil {
+		t.Fatal(err)
+	}
+	if !hidden.IsSet() {
+		t.Fatalf("%s should be set", hidden.DescriptiveName())
+	}
+
+	u, err := NewUsage("test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := u.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	written := sb.String()
+
+	if !strings.Contains(written, visible.DescriptiveName()) {
+		t.Fatalf("%s should be in the usage: %s", visible.DescriptiveName(), written)
+	}
+	if strings.Contains(written, hidden.DescriptiveName()) {
+		t.Fatalf("%s should not be in the usage: %s", hidden.DescriptiveName(), written)
+	}
+}
+
+func TestGroupRequired(t *testing.T) {
+	opts := NewOptions()
+
+	file, err := opts.NewLongArgumented("file", "FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Require()
+	verbose, err := opts.NewLongFlag("verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUsage("test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := u.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if written := sb.String(); strings.Contains(written, "Required:") {
+		t.Fatalf("No required section expected by default: %s", written)
+	}
+
+	u.GroupRequired(true)
+
+	sb.Reset()
+	if err := u.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	written := sb.String()
+
+	requiredAt := strings.Index(written, "Required:")
+	optionsAt := strings.Index(written, "Options:")
+	if requiredAt < 0 || optionsAt < requiredAt {
+		t.Fatalf("The required section should precede the options section: %s", written)
+	}
+	if at := strings.Index(written, file.DescriptiveName()); at < requiredAt || at > optionsAt {
+		t.Fatalf("%s should be in the required section: %s", file.DescriptiveName(), written)
+	}
+	if at := strings.Index(written, verbose.DescriptiveName()); at < optionsAt {
+		t.Fatalf("%s should be in the options section: %s", verbose.DescriptiveName(), written)
+	}
+}
+
+func TestDefaultedArgumentInUsage(t *testing.T) {
+	opts := NewOptions()
+
+	file, err := opts.NewArgumented("file", 'f', "FILE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Require()
+	addr, err := opts.NewArgumented("addr", 'a', "ADDR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.SetDefault("127.0.0.1:8888")
+	level, err := opts.NewLongArgumented("level", "LEVEL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	level.SetDefault("info")
+	level.SetOptionalArgument("debug")
+
+	u, err := NewUsage("test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := u.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	written := sb.String()
+
+	for _, expected := range []string{
+		"-f <FILE>  or  --file <FILE>",
+		"[-a <ADDR>  or  --addr <ADDR>]",
+		"--level[=<LEVEL>]",
+	} {
+		if !strings.Contains(written, expected) {
+			t.Fatalf("%s should be in the usage: %s", expected, written)
+		}
+	}
+	if addr.DescriptiveName() != "-a <ADDR>  or  --addr <ADDR>" {
+		t.Fatalf("Descriptive name changed: %s", addr.DescriptiveName())
+	}
+}
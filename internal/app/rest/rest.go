@@ -4,15 +4,24 @@
 package rest
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Values contains values extracted from the request URI
@@ -20,6 +29,7 @@ import (
 // "/articles/:id" values contains value of the id.
 type Values struct {
 	values map[string]string
+	route  string
 }
 
 func newValues() *Values {
@@ -28,6 +38,11 @@ func newValues() *Values {
 	}
 }
 
+// Route returns the route matched by the request as it was registered, for example, "/articles/:id".
+func (v *Values) Route() string {
+	return v.route
+}
+
 // Has returns true if the values contains a value for the specified name.
 func (v *Values) Has(name string) bool {
 	_, ok := v.values[name]
@@ -48,6 +63,63 @@ func (v *Values) Int(name string) (value int, err error) {
 	return strconv.Atoi(val)
 }
 
+// Bind sets the fields of the struct dst points to, which are tagged with `route:"<name>"`,
+// to the values with the names. Fields of string, bool and integer types are supported.
+func (v *Values) Bind(dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("a pointer to a struct expected, got %T", dst)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		name, ok := rt.Field(i).Tag.Lookup("route")
+		if !ok {
+			continue
+		}
+		val, ok := v.values[name]
+		if !ok {
+			return fmt.Errorf("no value for the name: %s", name)
+		}
+		if err := setField(rv.Field(i), val); err != nil {
+			return fmt.Errorf("cannot bind value '%s' of %s to field %s: %v", val, name, rt.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, val string) error {
+	if !f.CanSet() {
+		return errors.New("the field isn't exported")
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(val, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(u)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
 // Dump dumps all values to an io.Writer.
 func (v *Values) Dump(w io.Writer) {
 	b, err := json.MarshalIndent(v.values, "", "  ")
@@ -56,77 +128,447 @@ func (v *Values) Dump(w io.Writer) {
 	}
 }
 
+// MaxJSONBodyBytes is the max size of a request body accepted by DecodeJSON.
+const MaxJSONBodyBytes = 1 << 20
+
+// DecodeJSON decodes the JSON body of the request into v.
+// It returns an error if the request's content type isn't application/json
+// or the body is larger than MaxJSONBodyBytes.
+func DecodeJSON(req *http.Request, v interface{}) error {
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/json" {
+		return fmt.Errorf("unsupported content type: '%s', expected: 'application/json'", contentType)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxJSONBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxJSONBodyBytes {
+		return fmt.Errorf("request body is too large, max %d bytes allowed", MaxJSONBodyBytes)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// RequestIDHeader is the header carrying the ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of an incoming request ID. Longer IDs are replaced.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request stored in its context by Srv.
+// It returns an empty string if there is no ID.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID returns true if the incoming ID is short and contains printable ASCII only.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random ID of 32 hex digits.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// DefaultMaxBodyBytes is the max size of a request body accepted by Srv by default.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Timeouts and limits of the http.Server started by Srv.Start. There is no write timeout
+// by default, since a handler may stream its response.
+const (
+	readHeaderTimeout  = 10 * time.Second
+	defaultReadTimeout = 30 * time.Second
+	defaultIdleTimeout = 120 * time.Second
+	maxHeaderBytes     = 64 << 10
+)
+
 // Handle handles http request for a route.
 type Handle func(v *Values, res http.ResponseWriter, req *http.Request) error
 
+// StatusHandle handles http request for a route and returns the status of the response.
+// Zero status means 200. The body written by the handler is sent after the status, so
+// the handler can write it before deciding on the status.
+type StatusHandle func(v *Values, res http.ResponseWriter, req *http.Request) (int, error)
+
+// WithStatus adapts the handler returning the status to be registered for any HTTP method.
+// The body isn't sent if the handler fails, so the error is answered with 500 as usual.
+// The status is ignored if the handler calls WriteHeader itself.
+func WithStatus(handler StatusHandle) Handle {
+	return func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		sr := &statusResponse{ResponseWriter: res}
+		status, err := handler(v, sr, req)
+		if err != nil {
+			return err
+		}
+		if sr.wroteHeader {
+			return nil
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 100 || status > 999 {
+			return fmt.Errorf("invalid status code: %d", status)
+		}
+		sr.WriteHeader(status)
+		return sr.err
+	}
+}
+
+// statusResponse holds the body written until the status is known.
+type statusResponse struct {
+	http.ResponseWriter
+	body        bytes.Buffer
+	wroteHeader bool
+	err         error // of writing the held body
+}
+
+func (r *statusResponse) Write(p []byte) (int, error) {
+	if r.wroteHeader {
+		return r.ResponseWriter.Write(p)
+	}
+	return r.body.Write(p)
+}
+
+func (r *statusResponse) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(status)
+	if r.body.Len() > 0 {
+		_, r.err = r.ResponseWriter.Write(r.body.Bytes())
+		r.body.Reset()
+	}
+}
+
+// Respond answers with the status and the body encoded as JSON. Nil body isn't encoded,
+// for example, for 204 No Content.
+func Respond(res http.ResponseWriter, status int, body interface{}) error {
+	if body == nil {
+		res.WriteHeader(status)
+		return nil
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	return json.NewEncoder(res).Encode(body)
+}
+
 // Srv is a REST server.
 type Srv struct {
-	addr      string
-	trees     map[string]*tree
-	treesLock sync.RWMutex
+	addr             string
+	trees            map[string]*tree
+	treesLock        sync.RWMutex
+	autoOptions      bool
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+	maxBodyBytes     int64
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	idleTimeout      time.Duration
+	requestIDs       bool
 }
 
 // NewSrv creates new instance of the Srv for the specified local address.
 func NewSrv(addr string) *Srv {
 	return &Srv{
-		addr:  addr,
-		trees: make(map[string]*tree),
+		addr:         addr,
+		trees:        make(map[string]*tree),
+		autoOptions:  true,
+		maxBodyBytes: DefaultMaxBodyBytes,
+		readTimeout:  defaultReadTimeout,
+		idleTimeout:  defaultIdleTimeout,
+		requestIDs:   true,
 	}
 }
 
-// Get registers new route for the HTTP GET requests.
-func (s *Srv) Get(url string, handler Handle) {
-	s.registerHandler(http.MethodGet, url, handler)
+// SetMaxBodyBytes sets the max size of a request body. Requests with larger declared
+// Content-Length are answered with 413, reading of larger bodies without it fails.
+// Zero or negative value disables the limit.
+func (s *Srv) SetMaxBodyBytes(n int64) {
+	s.maxBodyBytes = n
+}
+
+// SetTimeouts sets the max durations of reading a request, writing a response and waiting
+// for the next request on a keep-alive connection. Zero disables the corresponding timeout.
+// The request's headers must be read within the read timeout, but not longer than 10 seconds.
+// It must be called before Start.
+func (s *Srv) SetTimeouts(read, write, idle time.Duration) {
+	s.readTimeout = read
+	s.writeTimeout = write
+	s.idleTimeout = idle
+}
+
+// SetRequestIDs enables or disables propagation of the request IDs. It's enabled by default.
+// The ID is taken from the X-Request-ID header of the request or generated if the header
+// is absent or invalid. The ID is echoed in the header of the response and stored in the context
+// of the request passed to the handlers, so it's available with RequestID.
+func (s *Srv) SetRequestIDs(enabled bool) {
+	s.requestIDs = enabled
+}
+
+// SetAutoOptions enables or disables automatic answering of the HTTP OPTIONS requests
+// with the methods registered for the requested path. It's enabled by default.
+// Routes registered for the OPTIONS method explicitly take precedence.
+func (s *Srv) SetAutoOptions(enabled bool) {
+	s.autoOptions = enabled
+}
+
+// SetNotFoundHandler sets the handler called for the requests which match no route.
+// If nil, the requests are answered with 404 and a plain text message.
+func (s *Srv) SetNotFoundHandler(h http.Handler) {
+	s.notFound = h
 }
 
-// Post registers new route for the HTTP POST requests.
-func (s *Srv) Post(url string, handler Handle) {
-	s.registerHandler(http.MethodPost, url, handler)
+// SetMethodNotAllowedHandler sets the handler called for the requests which path is mapped
+// for other HTTP methods only. The Allow header with these methods is set before the call.
+// If nil, the requests are handled as not found.
+func (s *Srv) SetMethodNotAllowedHandler(h http.Handler) {
+	s.methodNotAllowed = h
 }
 
-// Put registers new route for the HTTP PUT requests.
-func (s *Srv) Put(url string, handler Handle) {
-	s.registerHandler(http.MethodPut, url, handler)
+// Get registers new route for the HTTP GET requests. It returns an error if the route
+// conflicts with a registered one, for example, the same path is registered already or
+// a segment is a value in one route and a constant in another one.
+func (s *Srv) Get(url string, handler Handle) error {
+	return s.registerHandler(http.MethodGet, url, handler)
 }
 
-// Delete registers new route for the HTTP DELETE requests.
-func (s *Srv) Delete(url string, handler Handle) {
-	s.registerHandler(http.MethodDelete, url, handler)
+// GetStatus registers new route for the HTTP GET requests with the handler returning the status
+// of the response. It returns an error as Get does.
+func (s *Srv) GetStatus(url string, handler StatusHandle) error {
+	return s.registerHandler(http.MethodGet, url, WithStatus(handler))
+}
+
+// Post registers new route for the HTTP POST requests. It returns an error as Get does.
+func (s *Srv) Post(url string, handler Handle) error {
+	return s.registerHandler(http.MethodPost, url, handler)
+}
+
+// Put registers new route for the HTTP PUT requests. It returns an error as Get does.
+func (s *Srv) Put(url string, handler Handle) error {
+	return s.registerHandler(http.MethodPut, url, handler)
+}
+
+// Delete registers new route for the HTTP DELETE requests. It returns an error as Get does.
+func (s *Srv) Delete(url string, handler Handle) error {
+	return s.registerHandler(http.MethodDelete, url, handler)
+}
+
+// Handle registers new route for the HTTP requests of each method specified.
+// It returns the first error of the registration as Get does. Nothing is registered
+// if the route conflicts with existing ones for any of the methods.
+func (s *Srv) Handle(methods []string, url string, handler Handle) error {
+	check := NewSrv("") // Conflicts are found on a copy, so the Srv stays intact on an error
+	for _, r := range s.routes() {
+		if err := check.registerHandler(r.method, r.path, r.handler); err != nil {
+			return err
+		}
+	}
+	for _, m := range methods {
+		if err := check.registerHandler(m, url, handler); err != nil {
+			return fmt.Errorf("%s %s: %v", m, url, err)
+		}
+	}
+
+	for _, m := range methods {
+		if err := s.registerHandler(m, url, handler); err != nil {
+			return fmt.Errorf("%s %s: %v", m, url, err)
+		}
+	}
+	return nil
+}
+
+// Mount registers all routes of the sub-router under the prefix, so the route "/counters"
+// of the sub-router mounted with the prefix "/files/:name" is served as "/files/:name/counters".
+// The values of the prefix's segments are available to the sub-router's handlers and Route
+// returns the full path. The prefix "/" merges the routes. Routes added to the sub-router
+// after the call aren't mounted. Nothing is mounted if a route conflicts with existing ones.
+func (s *Srv) Mount(prefix string, sub *Srv) error {
+	prefix = "/" + strings.Trim(strings.TrimSpace(prefix), "/")
+
+	mounted := sub.routes()
+	for i := range mounted {
+		mounted[i].path = joinPath(prefix, mounted[i].path)
+	}
+
+	check := NewSrv("") // Conflicts are found on a copy, so the Srv stays intact on an error
+	for _, r := range append(s.routes(), mounted...) {
+		if err := check.registerHandler(r.method, r.path, r.handler); err != nil {
+			return fmt.Errorf("cannot mount %s under %s: %v", r.path, prefix, err)
+		}
+	}
+
+	for _, r := range mounted {
+		if err := s.registerHandler(r.method, r.path, r.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinPath returns the path relative to the prefix.
+func joinPath(prefix, path string) string {
+	rel := strings.Trim(strings.TrimSpace(path), "/")
+	switch {
+	case rel == "":
+		return prefix
+	case prefix == "/":
+		return prefix + rel
+	default:
+		return prefix + "/" + rel
+	}
+}
+
+// route is a handler registered for a path and an HTTP method.
+type route struct {
+	method  string
+	path    string
+	handler Handle
+}
+
+// routes returns all routes registered in the Srv.
+func (s *Srv) routes() (rs []route) {
+	s.treesLock.RLock()
+	defer s.treesLock.RUnlock()
+
+	for m, t := range s.trees {
+		t.root.walk(func(n *node) {
+			if n.handler != nil {
+				rs = append(rs, route{method: m, path: n.route, handler: n.handler})
+			}
+		})
+	}
+	return rs
 }
 
 // Start starts the Srv.
 func (s *Srv) Start() error {
-	return http.ListenAndServe(s.addr, s)
+	return s.newServer().ListenAndServe()
+}
+
+func (s *Srv) newServer() *http.Server {
+	headerTimeout := readHeaderTimeout
+	if s.readTimeout > 0 && s.readTimeout < headerTimeout {
+		headerTimeout = s.readTimeout
+	}
+	return &http.Server{
+		Addr:              s.addr,
+		Handler:           s,
+		ReadHeaderTimeout: headerTimeout,
+		ReadTimeout:       s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
 }
 
 // ServeHTTP implements http.Handler and routes incoming requests.
 func (s *Srv) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	var t *tree
-	s.treesLock.RLock()
-	func() {
-		defer s.treesLock.RUnlock()
-		t = s.trees[req.Method]
-	}()
+	if s.requestIDs {
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		res.Header().Set(RequestIDHeader, id)
+		req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+	}
 
-	if t == nil {
-		httpError(res, http.StatusNotFound, fmt.Sprintf("Unmapped HTTP method: %s", req.Method))
-		return
+	if s.maxBodyBytes > 0 {
+		if req.ContentLength > s.maxBodyBytes {
+			httpError(res, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body is too large, max %d bytes allowed", s.maxBodyBytes))
+			return
+		}
+		req.Body = http.MaxBytesReader(res, req.Body, s.maxBodyBytes)
 	}
 
-	v, h, err := t.resolvePath(req.RequestURI)
-	if err != nil || h == nil {
-		httpError(res, http.StatusNotFound, fmt.Sprintf("URL %s not mapped", req.RequestURI))
+	t := s.tree(req.Method)
+
+	if t != nil {
+		v, h, err := t.resolvePath(req.RequestURI)
+		if err == nil && h != nil {
+			err = h(v, res, req)
+			if err != nil {
+				httpError(res, http.StatusInternalServerError, err)
+			}
+			return
+		}
+	}
+
+	autoOptions := req.Method == http.MethodOptions && s.autoOptions
+	if autoOptions || s.methodNotAllowed != nil {
+		if allowed := s.allowedMethods(req.RequestURI); len(allowed) > 0 {
+			res.Header().Set("Allow", strings.Join(allowed, ", "))
+			if autoOptions {
+				res.WriteHeader(http.StatusNoContent)
+			} else {
+				s.methodNotAllowed.ServeHTTP(res, req)
+			}
+			return
+		}
+	}
+
+	if s.notFound != nil {
+		s.notFound.ServeHTTP(res, req)
 		return
 	}
 
-	err = h(v, res, req)
-	if err != nil {
-		httpError(res, http.StatusInternalServerError, err)
+	if t == nil {
+		httpError(res, http.StatusNotFound, fmt.Sprintf("Unmapped HTTP method: %s", req.Method))
 		return
 	}
+
+	httpError(res, http.StatusNotFound, fmt.Sprintf("URL %s not mapped", req.RequestURI))
+}
+
+func (s *Srv) tree(httpMethod string) *tree {
+	s.treesLock.RLock()
+	defer s.treesLock.RUnlock()
+	return s.trees[httpMethod]
 }
 
-func (s *Srv) registerHandler(httpMethod string, url string, handler Handle) {
+// allowedMethods returns sorted methods which have a handler for the path.
+// For the path "*" it returns all methods having at least one route.
+func (s *Srv) allowedMethods(path string) (methods []string) {
+	s.treesLock.RLock()
+	defer s.treesLock.RUnlock()
+
+	for m, t := range s.trees {
+		if path != "*" {
+			if _, h, err := t.resolvePath(path); err != nil || h == nil {
+				continue
+			}
+		}
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+func (s *Srv) registerHandler(httpMethod string, url string, handler Handle) error {
 	var t *tree
 	s.treesLock.Lock()
 	func() {
@@ -137,7 +579,7 @@ func (s *Srv) registerHandler(httpMethod string, url string, handler Handle) {
 			s.trees[httpMethod] = t
 		}
 	}()
-	t.applyPath(url, handler)
+	return t.applyPath(url, handler)
 }
 
 func httpError(res http.ResponseWriter, code int, cause interface{}) {
@@ -218,6 +660,7 @@ type node struct {
 	segment  string
 	nodeType nodeType
 	handler  Handle
+	route    string // the path the handler is registered for
 	next     map[string]*node
 }
 
@@ -268,6 +711,17 @@ func (n *node) applyNext(segment string) (rn *node, err error) {
 	return rn, nil
 }
 
+// walk calls fn for the node and all nodes following it.
+func (n *node) walk(fn func(n *node)) {
+	if n == nil {
+		return
+	}
+	fn(n)
+	for _, nn := range n.next {
+		nn.walk(fn)
+	}
+}
+
 type tree struct {
 	root *node
 }
@@ -305,6 +759,7 @@ func (t *tree) applyPath(path string, handler Handle) (err error) {
 	}
 
 	n.handler = handler
+	n.route = path
 
 	return nil
 }
@@ -355,5 +810,7 @@ Search:
 		return nil, nil, errors.New("no associated handler found")
 	}
 
+	values.route = n.route
+
 	return values, n.handler, nil
 }
@@ -4,7 +4,15 @@
 package rest
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPath(t *testing.T) {
@@ -42,3 +50,472 @@ func validate(t *testing.T, path string, expected ...string) {
 
 func TestRestApp(t *testing.T) {
 }
+
+func noop(v *Values, res http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+
+func serve(s *Srv, method, target string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	s.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+	return res
+}
+
+func TestAutoOptions(t *testing.T) {
+	s := NewSrv("")
+	s.Get("/counter/:id", noop)
+	s.Post("/counter/:id", noop)
+	s.Delete("/static", noop)
+
+	res := serve(s, http.MethodOptions, "/counter/1")
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusNoContent)
+	}
+	if allow := res.Header().Get("Allow"); allow != "GET, POST" {
+		t.Fatalf("Allow '%s', expected '%s'", allow, "GET, POST")
+	}
+
+	res = serve(s, http.MethodOptions, "*")
+	if allow := res.Header().Get("Allow"); allow != "DELETE, GET, POST" {
+		t.Fatalf("Allow '%s', expected '%s'", allow, "DELETE, GET, POST")
+	}
+
+	res = serve(s, http.MethodOptions, "/unknown")
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusNotFound)
+	}
+
+	s.SetAutoOptions(false)
+
+	res = serve(s, http.MethodOptions, "/counter/1")
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestNotFoundHandlers(t *testing.T) {
+	s := NewSrv("")
+	s.Get("/counter/:id", noop)
+
+	res := serve(s, http.MethodGet, "/unknown")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), "not mapped") {
+		t.Fatalf("Default 404 expected, got %d: %s", res.Code, res.Body.String())
+	}
+	res = serve(s, http.MethodPost, "/counter/1")
+	if res.Code != http.StatusNotFound || !strings.Contains(res.Body.String(), "Unmapped HTTP method") {
+		t.Fatalf("Default 404 expected, got %d: %s", res.Code, res.Body.String())
+	}
+
+	s.SetNotFoundHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte(`{"error":"not found"}`))
+	}))
+	s.SetMethodNotAllowedHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+
+	res = serve(s, http.MethodGet, "/unknown")
+	if res.Code != http.StatusNotFound || res.Body.String() != `{"error":"not found"}` {
+		t.Fatalf("Custom 404 expected, got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = serve(s, http.MethodPost, "/counter/1")
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := res.Header().Get("Allow"); allow != "GET" {
+		t.Fatalf("Allow '%s', expected '%s'", allow, "GET")
+	}
+
+	res = serve(s, http.MethodGet, "/counter/1")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusOK)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	s := NewSrv("")
+	s.SetMaxBodyBytes(16)
+	s.Post("/body", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		res.Write(body)
+		return nil
+	})
+
+	post := func(body io.Reader) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		s.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/body", body))
+		return res
+	}
+
+	res := post(strings.NewReader("small body"))
+	if res.Code != http.StatusOK || res.Body.String() != "small body" {
+		t.Fatalf("Got %d: %s, expected %d", res.Code, res.Body.String(), http.StatusOK)
+	}
+
+	res = post(strings.NewReader(strings.Repeat("x", 17)))
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// No Content-Length, so the body is cut while reading
+	res = post(ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 17))))
+	if res.Code != http.StatusInternalServerError || strings.Contains(res.Body.String(), "xxx") {
+		t.Fatalf("Got %d: %s, expected %d", res.Code, res.Body.String(), http.StatusInternalServerError)
+	}
+
+	s.SetMaxBodyBytes(0)
+
+	res = post(strings.NewReader(strings.Repeat("x", 17)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusOK)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	s := NewSrv("")
+	s.SetTimeouts(200*time.Millisecond, time.Second, time.Second)
+	s.Get("/ping", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		_, err := res.Write([]byte("pong"))
+		return err
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := s.newServer()
+	go server.Serve(l)
+	defer server.Close()
+
+	res, err := http.Get("http://" + l.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(body) != "pong" {
+		t.Fatalf("Got %s (%v), expected pong", body, err)
+	}
+
+	// A slow client doesn't complete the headers
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	ioutil.ReadAll(conn) // Returns when the server closes the connection
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Fatalf("The slow client must be disconnected by the server, waited %v", elapsed)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	s := NewSrv("")
+	for _, route := range []string{"/counter/:id_label", "/counters", "/static/:label/value"} {
+		route := route
+		s.Get(route, func(v *Values, res http.ResponseWriter, req *http.Request) error {
+			if v.Route() != route {
+				t.Errorf("Got route %s, expected %s", v.Route(), route)
+			}
+			_, err := res.Write([]byte(v.Route()))
+			return err
+		})
+	}
+
+	for target, expected := range map[string]string{
+		"/counter/123":        "/counter/:id_label",
+		"/counters":           "/counters",
+		"/static/abc/value":   "/static/:label/value",
+		"/counter/io.read%20": "/counter/:id_label",
+	} {
+		res := serve(s, http.MethodGet, target)
+		if res.Code != http.StatusOK || res.Body.String() != expected {
+			t.Fatalf("Got %d: %s for %s, expected %s", res.Code, res.Body.String(), target, expected)
+		}
+	}
+}
+
+func TestHandleMethods(t *testing.T) {
+	s := NewSrv("")
+	s.Handle([]string{http.MethodGet, http.MethodPost}, "/counter/:id", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		_, err := res.Write([]byte(req.Method + " " + v.String("id")))
+		return err
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		res := serve(s, method, "/counter/1")
+		if res.Code != http.StatusOK || res.Body.String() != method+" 1" {
+			t.Fatalf("Got %d: %s for %s", res.Code, res.Body.String(), method)
+		}
+	}
+
+	res := serve(s, http.MethodPut, "/counter/1")
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("Status %d, expected %d", res.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+	s := NewSrv("")
+	for _, route := range []string{"/counters", "/static/:label", "/counter/:id"} {
+		if err := s.Get(route, noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, route := range []string{"/counters", "/static/label", "counters", ""} {
+		if err := s.Get(route, noop); err == nil {
+			t.Fatalf("An error expected for %s", route)
+		}
+	}
+
+	if err := s.Post("/counters", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Handle([]string{http.MethodPut, http.MethodGet}, "/counter/:id", noop); err == nil ||
+		!strings.HasPrefix(err.Error(), "GET /counter/:id") {
+		t.Fatalf("An error expected for GET, got %v", err)
+	}
+	if res := serve(s, http.MethodPut, "/counter/1"); res.Code != http.StatusNotFound {
+		t.Fatalf("Status %d, expected %d, since the failed Handle must register nothing", res.Code, http.StatusNotFound)
+	}
+	if err := s.Put("/counter/:id", noop); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	handler := func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		_, err := fmt.Fprintf(res, "%s %s %s", v.String("name"), v.String("id"), v.Route())
+		return err
+	}
+
+	sub := NewSrv("")
+	sub.Get("/", handler)
+	sub.Get("/counters", handler)
+	sub.Get("/counter/:id/", handler)
+
+	s := NewSrv("")
+	s.Get("/files", handler)
+	if err := s.Mount(" /files/:name/ ", sub); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, expected := range map[string]string{
+		"/files":             "  /files",
+		"/files/a":           "a  /files/:name",
+		"/files/a/counters":  "a  /files/:name/counters",
+		"/files/b/counter/7": "b 7 /files/:name/counter/:id",
+	} {
+		res := serve(s, http.MethodGet, target)
+		if res.Code != http.StatusOK || res.Body.String() != expected {
+			t.Fatalf("Got %d: '%s' for %s, expected '%s'", res.Code, res.Body.String(), target, expected)
+		}
+	}
+
+	if res := serve(sub, http.MethodGet, "/counter/7"); res.Code != http.StatusOK || res.Body.String() != " 7 /counter/:id/" {
+		t.Fatalf("Got %d: '%s' from the sub-router", res.Code, res.Body.String())
+	}
+
+	conflicting := NewSrv("")
+	conflicting.Get("/statics", handler)
+	conflicting.Get("/counters", handler)
+	if err := s.Mount("/files/:name", conflicting); err == nil {
+		t.Fatal("An error expected for the route mounted already")
+	}
+	if res := serve(s, http.MethodGet, "/files/a/statics"); res.Code != http.StatusNotFound {
+		t.Fatalf("Status %d, expected nothing mounted on a conflict", res.Code)
+	}
+
+	if err := s.Mount("/", conflicting); err != nil {
+		t.Fatal(err)
+	}
+	if res := serve(s, http.MethodGet, "/statics"); res.Code != http.StatusOK || res.Body.String() != "  /statics" {
+		t.Fatalf("Got %d: '%s' for the routes mounted to the root", res.Code, res.Body.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := NewSrv("")
+	s.Get("/id", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		_, err := res.Write([]byte(RequestID(req.Context())))
+		return err
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	res := httptest.NewRecorder()
+	s.ServeHTTP(res, req)
+	if id := res.Header().Get(RequestIDHeader); id != "abc-123" || res.Body.String() != "abc-123" {
+		t.Fatalf("Got ID %s in the header and %s in the context, expected abc-123", id, res.Body.String())
+	}
+
+	res = serve(s, http.MethodGet, "/id")
+	generated := res.Header().Get(RequestIDHeader)
+	if len(generated) != 32 || res.Body.String() != generated {
+		t.Fatalf("Got ID %s in the header and %s in the context, expected a generated one", generated, res.Body.String())
+	}
+	if res = serve(s, http.MethodGet, "/id"); res.Header().Get(RequestIDHeader) == generated {
+		t.Fatal("Generated IDs must differ")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	res = httptest.NewRecorder()
+	s.ServeHTTP(res, req)
+	if id := res.Header().Get(RequestIDHeader); len(id) != 32 {
+		t.Fatalf("Got ID %s, expected a generated one instead of too long", id)
+	}
+
+	if res = serve(s, http.MethodGet, "/unknown"); res.Header().Get(RequestIDHeader) == "" {
+		t.Fatal("Unmapped requests must get an ID as well")
+	}
+
+	s.SetRequestIDs(false)
+	if res = serve(s, http.MethodGet, "/id"); res.Header().Get(RequestIDHeader) != "" || res.Body.String() != "" {
+		t.Fatalf("Got ID %s, expected none", res.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestBind(t *testing.T) {
+	type item struct {
+		ID      int    `route:"id"`
+		SKU     string `route:"sku"`
+		Ignored string
+	}
+
+	s := NewSrv("")
+	s.Get("/order/:id/item/:sku", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		var it item
+		if err := v.Bind(&it); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(res, "%d %s", it.ID, it.SKU)
+		return err
+	})
+
+	res := serve(s, http.MethodGet, "/order/42/item/ab-1")
+	if res.Code != http.StatusOK || res.Body.String() != "42 ab-1" {
+		t.Fatalf("Got %d: %s, expected 42 ab-1", res.Code, res.Body.String())
+	}
+
+	res = serve(s, http.MethodGet, "/order/x42/item/ab-1")
+	if res.Code != http.StatusInternalServerError || !strings.Contains(res.Body.String(), "field ID") {
+		t.Fatalf("Got %d: %s, expected a conversion error", res.Code, res.Body.String())
+	}
+
+	v := newValues()
+	v.values["id"] = "7"
+	v.values["flag"] = "true"
+	var typed struct {
+		ID   int64 `route:"id"`
+		Flag bool  `route:"flag"`
+	}
+	if err := v.Bind(&typed); err != nil || typed.ID != 7 || !typed.Flag {
+		t.Fatalf("Got %+v (%v), expected ID 7 and Flag true", typed, err)
+	}
+	if err := v.Bind(typed); err == nil {
+		t.Fatal("A struct passed by value must be rejected")
+	}
+	var missing struct {
+		Name string `route:"name"`
+	}
+	if err := v.Bind(&missing); err == nil {
+		t.Fatal("A missed value must be reported")
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	newRequest := func(contentType, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/counter/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	var v struct {
+		Value int64 `json:"value"`
+	}
+
+	err := DecodeJSON(newRequest("application/json; charset=utf-8", `{"value": 42}`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 42 {
+		t.Fatalf("Decoded value %d, expected %d", v.Value, 42)
+	}
+
+	err = DecodeJSON(newRequest("text/plain", `{"value": 42}`), &v)
+	if err == nil || !strings.Contains(err.Error(), "content type") {
+		t.Fatalf("A content type error expected, got: %v", err)
+	}
+
+	oversized := `{"value": 42, "padding": "` + strings.Repeat("x", MaxJSONBodyBytes) + `"}`
+	err = DecodeJSON(newRequest("application/json", oversized), &v)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("A size error expected, got: %v", err)
+	}
+}
+
+func TestStatusHandle(t *testing.T) {
+	s := NewSrv("")
+	if err := s.GetStatus("/accepted", func(v *Values, res http.ResponseWriter, req *http.Request) (int, error) {
+		res.Header().Set("Content-Type", "text/plain")
+		_, err := io.WriteString(res, "queued")
+		return http.StatusAccepted, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetStatus("/default", func(v *Values, res http.ResponseWriter, req *http.Request) (int, error) {
+		return 0, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GetStatus("/failed", func(v *Values, res http.ResponseWriter, req *http.Request) (int, error) {
+		io.WriteString(res, "partial")
+		return http.StatusCreated, fmt.Errorf("failed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Post("/created", WithStatus(func(v *Values, res http.ResponseWriter, req *http.Request) (int, error) {
+		return 0, Respond(res, http.StatusCreated, map[string]int{"id": 7})
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("/created", func(v *Values, res http.ResponseWriter, req *http.Request) error {
+		return Respond(res, http.StatusNoContent, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		url    string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/accepted", http.StatusAccepted, "queued"},
+		{http.MethodGet, "/default", http.StatusOK, ""},
+		{http.MethodGet, "/failed", http.StatusInternalServerError, "An error: failed"},
+		{http.MethodPost, "/created", http.StatusCreated, "{\"id\":7}\n"},
+		{http.MethodDelete, "/created", http.StatusNoContent, ""},
+	} {
+		res := httptest.NewRecorder()
+		s.ServeHTTP(res, httptest.NewRequest(tc.method, tc.url, nil))
+		if res.Code != tc.code {
+			t.Fatalf("%s %s: status %d, expected %d", tc.method, tc.url, res.Code, tc.code)
+		}
+		if body := res.Body.String(); body != tc.body {
+			t.Fatalf("%s %s: body '%s', expected '%s'", tc.method, tc.url, body, tc.body)
+		}
+	}
+}
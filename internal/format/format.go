@@ -0,0 +1,50 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package format
+
+import (
+	"math"
+	"strconv"
+)
+
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+var countUnits = []string{"", "K", "M", "G", "T", "P", "E"}
+
+// HumanBytes formats a number of bytes with binary units, for example, 1536 is "1.5KiB".
+func HumanBytes(v int64) string {
+	return human(v, 1024, byteUnits)
+}
+
+// HumanCount formats a number with decimal units, for example, 1500 is "1.5K".
+func HumanCount(v int64) string {
+	return human(v, 1000, countUnits)
+}
+
+func human(v int64, base uint64, units []string) string {
+	sign := ""
+	m := uint64(v)
+	if v < 0 {
+		sign = "-"
+		m = uint64(-(v + 1)) + 1 // math.MinInt64 can't be negated
+	}
+
+	if m < base {
+		return sign + strconv.FormatUint(m, 10) + units[0]
+	}
+
+	f := float64(m)
+	i := 0
+	for f >= float64(base) && i < len(units)-1 {
+		f /= float64(base)
+		i++
+	}
+	// 999999 is "1.0M", not "1000.0K"
+	if math.Round(f*10)/10 >= float64(base) && i < len(units)-1 {
+		f /= float64(base)
+		i++
+	}
+
+	return sign + strconv.FormatFloat(f, 'f', 1, 64) + units[i]
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package format
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHumanCount(t *testing.T) {
+	for v, expected := range map[int64]string{
+		0:             "0",
+		999:           "999",
+		1000:          "1.0K",
+		1024:          "1.0K",
+		1500:          "1.5K",
+		999949:        "999.9K",
+		999999:        "1.0M",
+		2300000:       "2.3M",
+		-999:          "-999",
+		-1500:         "-1.5K",
+		math.MaxInt64: "9.2E",
+		math.MinInt64: "-9.2E",
+	} {
+		if s := HumanCount(v); s != expected {
+			t.Fatalf("HumanCount(%d) is '%s', expected '%s'", v, s, expected)
+		}
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for v, expected := range map[int64]string{
+		0:       "0B",
+		999:     "999B",
+		1000:    "1000B",
+		1023:    "1023B",
+		1024:    "1.0KiB",
+		1536:    "1.5KiB",
+		1048575: "1.0MiB",
+		-1024:   "-1.0KiB",
+		-1:      "-1B",
+	} {
+		if s := HumanBytes(v); s != expected {
+			t.Fatalf("HumanBytes(%d) is '%s', expected '%s'", v, s, expected)
+		}
+	}
+}
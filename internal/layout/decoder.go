@@ -12,7 +12,8 @@ import (
 
 // Decoder decodes
 type Decoder struct {
-	Layout Layout
+	Layout      Layout
+	valueStride int
 }
 
 // NewDecoder creates
@@ -23,18 +24,20 @@ func NewDecoder(buf *offheap.Buffer) *Decoder {
 	metadataLength := int(header.GetInt32(headerMetadataLengthOffset))
 	valuesLength := int(header.GetInt32(headerValuesLengthOffset))
 
-	return &Decoder{
-		Layout: Layout{
-			Header:           header,
-			Statics:          buf.Slice(uintptr(HeaderLength()), staticsLength),
-			CountersMetadata: buf.Slice(uintptr(HeaderLength()+int(staticsLength)), metadataLength),
-			CountersValues:   buf.Slice(uintptr(HeaderLength()+int(staticsLength+metadataLength)), valuesLength),
-		},
-	}
+	return NewDecoderWithBuffers(header,
+		buf.Slice(uintptr(HeaderLength()), staticsLength),
+		buf.Slice(uintptr(HeaderLength()+int(staticsLength)), metadataLength),
+		buf.Slice(uintptr(HeaderLength()+int(staticsLength+metadataLength)), valuesLength),
+	)
 }
 
 // NewDecoderWithBuffers creates
 func NewDecoderWithBuffers(header, statics, countersMetadata, countersValues *offheap.Buffer) *Decoder {
+	valueStride := int(header.GetInt32(headerValueStrideOffset))
+	if valueStride == 0 {
+		valueStride = DefaultValueStride
+	}
+
 	return &Decoder{
 		Layout: Layout{
 			Header:           header,
@@ -42,14 +45,30 @@ func NewDecoderWithBuffers(header, statics, countersMetadata, countersValues *of
 			CountersMetadata: countersMetadata,
 			CountersValues:   countersValues,
 		},
+		valueStride: valueStride,
 	}
 }
 
+// Features returns the bitfield of the features used by the file.
+func (d *Decoder) Features() uint32 {
+	return uint32(d.Layout.Header.GetInt32Volatile(headerFeaturesOffset))
+}
+
+// Epoch returns the epoch of the values.
+func (d *Decoder) Epoch() int64 {
+	return d.Layout.Header.GetInt64Volatile(headerEpochOffset)
+}
+
 // Version returns
 func (d *Decoder) Version() int32 {
 	return d.Layout.Header.GetInt32Volatile(headerCountersVersionOffset)
 }
 
+// ValueStride returns the number of bytes occupied by a counter's value.
+func (d *Decoder) ValueStride() int {
+	return d.valueStride
+}
+
 // Pid returns
 func (d *Decoder) Pid() int64 {
 	return d.Layout.Header.GetInt64Volatile(headerPidOffsert)
@@ -87,6 +106,30 @@ func (d *Decoder) ForEachStatic(consumer func(label, value string) bool) {
 	}
 }
 
+// StaticsInto appends label/value pairs of all statics to dst and returns the extended slice.
+// Passing the slice returned by a previous call, truncated to zero length, allows to reuse its memory.
+func (d *Decoder) StaticsInto(dst [][2]string) [][2]string {
+	statics := d.Layout.Statics
+
+	numOfStatics := int(statics.GetInt32Volatile(uintptr(staticsNumberOfStaticsOffset)))
+
+	offset := staticsRecordsOffset
+
+	for i := 0; i < numOfStatics; i++ {
+		labelLen := int(statics.GetInt32(uintptr(offset + staticsLabelLengthOffset)))
+		valueLen := int(statics.GetInt32(uintptr(offset + staticsValueLengthOffset)))
+
+		dst = append(dst, [2]string{
+			statics.GetString(uintptr(offset+staticsLabelOffset), labelLen),
+			statics.GetString(uintptr(offset+staticsLabelOffset+labelLen), valueLen),
+		})
+
+		offset += staticsRecordLength(labelLen, valueLen)
+	}
+
+	return dst
+}
+
 // GetStaticValue returns
 func (d *Decoder) GetStaticValue(label string) (v string, err error) {
 	offset := staticsNumberOfStaticsOffset
@@ -120,45 +163,104 @@ func (d *Decoder) GetStaticValue(label string) (v string, err error) {
 
 // ForEachCounter iterates
 func (d *Decoder) ForEachCounter(consumer func(id, value int64, label string) bool) {
+	d.ForEachTypedCounter(func(id int64, counterType CounterType, value int64, label string) bool {
+		return consumer(id, value, label)
+	})
+}
+
+// ForEachTypedCounter iterates over the counters as ForEachCounter does, passing the type of each counter as well.
+func (d *Decoder) ForEachTypedCounter(consumer func(id int64, counterType CounterType, value int64, label string) bool) {
+	d.forEachTypedCounter(consumer, false)
+}
+
+// ForEachTypedCounterLive iterates over the counters as ForEachTypedCounter does, but scans all the slots
+// up to the capacity instead of stopping at the first never used one, so no allocated counter is missed
+// even if the slots are claimed out of order while the writer adds counters.
+func (d *Decoder) ForEachTypedCounterLive(consumer func(id int64, counterType CounterType, value int64, label string) bool) {
+	d.forEachTypedCounter(consumer, true)
+}
+
+func (d *Decoder) forEachTypedCounter(consumer func(id int64, counterType CounterType, value int64, label string) bool,
+	allSlots bool) {
 	metadata := d.Layout.CountersMetadata
-	values := d.Layout.CountersValues
 
 	metadataOffset := 0
 	valueOffset := 0
 
-Stop:
-	for metadataOffset < metadata.Capacity() {
-		idStatusOffset := metadataOffset + metadataCounterIDStatusOffset
+	for metadataOffset < metadata.Capacity() && d.hasValue(valueOffset) {
+		idStatus, counterType, value, label, ok := d.readCounter(metadataOffset, valueOffset)
+		if !allSlots && extractStatus(idStatus) == counterStatusNotUsed {
+			break
+		}
+		if ok && !consumer(extractID(idStatus), counterType, value, label) {
+			return
+		}
 
-		idStatus := metadata.GetInt64Volatile(uintptr(idStatusOffset))
+		metadataOffset += metadataRecordLength
+		valueOffset += d.valueStride
+	}
+}
+
+// hasValue returns false if the value at the offset is out of the values' region. It happens
+// for a malformed file which metadata implies more counters than its values' region contains.
+func (d *Decoder) hasValue(valueOffset int) bool {
+	return valueOffset+sizeOfInt64 <= d.Layout.CountersValues.Capacity()
+}
+
+// generation returns the generation of the slot, which is incremented on each allocation. It must be
+// read before the id/status word to be compared with unchanged.
+func (d *Decoder) generation(metadataOffset int) int64 {
+	return d.Layout.CountersMetadata.GetInt64Volatile(uintptr(metadataOffset + metadataGenerationOffset))
+}
 
-		switch status := extractStatus(idStatus); status {
-		case counterStatusNotUsed:
-			break Stop
+// unchanged returns true if the slot still holds the same allocation of the counter. Comparing
+// the id/status word only isn't enough, since the slot can be reused by a counter with the same id.
+func (d *Decoder) unchanged(metadataOffset int, idStatus, generation int64) bool {
+	metadata := d.Layout.CountersMetadata
+	return metadata.GetInt64Volatile(uintptr(metadataOffset+metadataCounterIDStatusOffset)) == idStatus &&
+		d.generation(metadataOffset) == generation
+}
 
-		case counterStatusAllocated:
-			id := extractID(idStatus)
+// maxSnapshotAttempts limits re-reading of a counter's record modified while reading.
+const maxSnapshotAttempts = 16
 
-			labelLength := int(metadata.GetInt32(uintptr(metadataOffset) + metadataLabelLengthOffset))
+// readCounter reads the counter's record so, that its label and value belong to the same counter.
+// The id, the status and the generation are read before and after the label and the value, and the whole
+// record is read again if they were changed meanwhile, since the slot could be freed and reused.
+// ok is false if the slot isn't allocated or no consistent snapshot was taken.
+func (d *Decoder) readCounter(metadataOffset, valueOffset int) (idStatus int64, counterType CounterType,
+	value int64, label string, ok bool) {
+	metadata := d.Layout.CountersMetadata
+	values := d.Layout.CountersValues
 
-			label := metadata.GetString(uintptr(metadataOffset+metadataLabelOffset), labelLength)
+	idStatusOffset := uintptr(metadataOffset + metadataCounterIDStatusOffset)
 
-			value := values.GetInt64(uintptr(valueOffset))
+	for attempt := 0; attempt < maxSnapshotAttempts; attempt++ {
+		generation := d.generation(metadataOffset)
 
-			// Make sure the counter's status wasn't changed yet to guarantee
-			// the value just read belongs to this counter.
-			if metadata.GetInt64Volatile(uintptr(idStatusOffset)) == idStatus {
-				if !consumer(id, value, label) {
-					return
-				}
-			}
+		idStatus = metadata.GetInt64Volatile(idStatusOffset)
 
-		default:
+		if extractStatus(idStatus) != counterStatusAllocated {
+			return idStatus, 0, 0, "", false
 		}
 
-		metadataOffset += metadataRecordLength
-		valueOffset += valuesCounterLength
+		counterType = CounterType(metadata.GetInt32(uintptr(metadataOffset + metadataCounterTypeOffset)))
+
+		labelLength := int(metadata.GetInt32(uintptr(metadataOffset + metadataLabelLengthOffset)))
+		if labelLength < 0 || labelLength > metadataLabelMaxLength { // Being written right now
+			labelLength = 0
+		}
+
+		label = metadata.GetString(uintptr(metadataOffset+metadataLabelOffset), labelLength)
+
+		value = values.GetInt64Volatile(uintptr(valueOffset))
+
+		if d.unchanged(metadataOffset, idStatus, generation) {
+			return idStatus, counterType, value, label, true
+		}
 	}
+
+	return idStatus, 0, 0, "", false
 }
 
 // GetCounterValue returns
@@ -172,6 +274,8 @@ func (d *Decoder) GetCounterValue(counterID int64) (value int64, err error) {
 	for metadataOffset < metadata.Capacity() {
 		idStatusOffset := metadataOffset + metadataCounterIDStatusOffset
 
+		generation := d.generation(metadataOffset)
+
 		idStatus := metadata.GetInt64Volatile(uintptr(idStatusOffset))
 
 		status := extractStatus(idStatus)
@@ -185,11 +289,14 @@ func (d *Decoder) GetCounterValue(counterID int64) (value int64, err error) {
 		if counterID == id {
 			switch status {
 			case counterStatusAllocated:
+				if !d.hasValue(valueOffset) {
+					return 0, fmt.Errorf("counter %d has no value in the values' region", counterID)
+				}
 				value = values.GetInt64(uintptr(valueOffset))
 
 				// Make sure the counter's status wasn't changed yet to guarantee
 				// the value just read belongs to this counter.
-				if metadata.GetInt64Volatile(uintptr(idStatusOffset)) == idStatus {
+				if d.unchanged(metadataOffset, idStatus, generation) {
 					return value, nil
 				}
 				continue
@@ -200,12 +307,185 @@ func (d *Decoder) GetCounterValue(counterID int64) (value int64, err error) {
 		}
 
 		metadataOffset += metadataRecordLength
-		valueOffset += valuesCounterLength
+		valueOffset += d.valueStride
 	}
 
 	return 0, fmt.Errorf("counter %d not found", counterID)
 }
 
+// GetCounterValues puts the values of the allocated counters with the ids in wanted into values
+// scanning the metadata once. The counters which aren't found or aren't allocated are skipped.
+func (d *Decoder) GetCounterValues(wanted map[int64]struct{}, values map[int64]int64) {
+	metadata := d.Layout.CountersMetadata
+
+	metadataOffset := 0
+	index := 0
+
+	for metadataOffset < metadata.Capacity() && len(values) < len(wanted) {
+		idStatus := metadata.GetInt64Volatile(uintptr(metadataOffset + metadataCounterIDStatusOffset))
+
+		status := extractStatus(idStatus)
+
+		if status == counterStatusNotUsed {
+			break
+		}
+
+		id := extractID(idStatus)
+
+		if _, ok := wanted[id]; ok && status == counterStatusAllocated && d.hasValue(index*d.valueStride) {
+			if _, done := values[id]; !done {
+				value, ok := d.GetCounterValueAt(d.slotAt(index))
+				if !ok {
+					continue // The slot was changed meanwhile, so check it again
+				}
+				values[id] = value
+			}
+		}
+
+		metadataOffset += metadataRecordLength
+		index++
+	}
+}
+
+// CounterSlot is the slot of an allocated counter returned by FindCounter.
+type CounterSlot struct {
+	Index      int
+	idStatus   int64
+	generation int64
+}
+
+// slotAt returns the slot with the index as of now.
+func (d *Decoder) slotAt(index int) CounterSlot {
+	metadataOffset := index * metadataRecordLength
+	generation := d.generation(metadataOffset)
+	return CounterSlot{
+		Index:      index,
+		idStatus:   d.Layout.CountersMetadata.GetInt64Volatile(uintptr(metadataOffset + metadataCounterIDStatusOffset)),
+		generation: generation,
+	}
+}
+
+// FindCounter returns the slot of the allocated counter.
+func (d *Decoder) FindCounter(counterID int64) (slot CounterSlot, err error) {
+	metadata := d.Layout.CountersMetadata
+
+	for index := 0; index*metadataRecordLength < metadata.Capacity(); index++ {
+		slot = d.slotAt(index)
+
+		status := extractStatus(slot.idStatus)
+
+		if status == counterStatusNotUsed {
+			break
+		}
+
+		if counterID == extractID(slot.idStatus) {
+			if status != counterStatusAllocated {
+				return CounterSlot{}, fmt.Errorf("counter %d isn't allocated", counterID)
+			}
+			return slot, nil
+		}
+	}
+
+	return CounterSlot{}, fmt.Errorf("counter %d not found", counterID)
+}
+
+// GetSlotIDStatus returns the id and the status of the counter in the slot with the index.
+// ok is false if there is no slot with the index.
+func (d *Decoder) GetSlotIDStatus(index int) (id int64, status uint8, ok bool) {
+	metadata := d.Layout.CountersMetadata
+	if index < 0 || index >= MaxCounters(metadata.Capacity()) {
+		return 0, 0, false
+	}
+	idStatus := metadata.GetInt64Volatile(uintptr(index*metadataRecordLength + metadataCounterIDStatusOffset))
+	return extractID(idStatus), extractStatus(idStatus), true
+}
+
+// GetCounterValueAt returns the value in the slot or false if the slot was reused.
+func (d *Decoder) GetCounterValueAt(slot CounterSlot) (value int64, ok bool) {
+	metadataOffset := slot.Index * metadataRecordLength
+
+	if extractStatus(slot.idStatus) != counterStatusAllocated || !d.hasValue(slot.Index*d.valueStride) ||
+		!d.unchanged(metadataOffset, slot.idStatus, slot.generation) {
+		return 0, false
+	}
+
+	value = d.Layout.CountersValues.GetInt64(uintptr(slot.Index * d.valueStride))
+
+	// Make sure the counter's status wasn't changed yet to guarantee
+	// the value just read belongs to this counter.
+	return value, d.unchanged(metadataOffset, slot.idStatus, slot.generation)
+}
+
+// GetCounterMinMax returns the value, the min, the max and the count of a CounterTypeMinMax counter.
+func (d *Decoder) GetCounterMinMax(counterID int64) (value, min, max, count int64, err error) {
+	metadata := d.Layout.CountersMetadata
+	values := d.Layout.CountersValues
+
+	for attempt := 0; attempt < maxSnapshotAttempts; attempt++ {
+		slot, err := d.FindCounter(counterID)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+
+		metadataOffset := slot.Index * metadataRecordLength
+		valueOffset := slot.Index * d.valueStride
+
+		counterType := CounterType(metadata.GetInt32(uintptr(metadataOffset + metadataCounterTypeOffset)))
+		if counterType == CounterTypeMinMax && valueOffset+MinMaxValueStride <= values.Capacity() {
+			value = values.GetInt64Volatile(uintptr(valueOffset))
+			min = values.GetInt64Volatile(uintptr(valueOffset + ValueMinOffset))
+			max = values.GetInt64Volatile(uintptr(valueOffset + ValueMaxOffset))
+			count = values.GetInt64Volatile(uintptr(valueOffset + ValueCountOffset))
+		}
+
+		// Make sure the counter's status wasn't changed yet to guarantee
+		// the type and the values just read belong to this counter.
+		if !d.unchanged(metadataOffset, slot.idStatus, slot.generation) {
+			continue
+		}
+		if counterType != CounterTypeMinMax {
+			return 0, 0, 0, 0, fmt.Errorf("counter %d isn't a min/max counter", counterID)
+		}
+		return value, min, max, count, nil
+	}
+	return 0, 0, 0, 0, fmt.Errorf("counter %d keeps changing", counterID)
+}
+
+// SetCounterValue sets the value of an allocated counter.
+func (d *Decoder) SetCounterValue(counterID, value int64) (err error) {
+	metadata := d.Layout.CountersMetadata
+	values := d.Layout.CountersValues
+
+	metadataOffset := 0
+	valueOffset := 0
+
+	for metadataOffset < metadata.Capacity() {
+		idStatus := metadata.GetInt64Volatile(uintptr(metadataOffset + metadataCounterIDStatusOffset))
+
+		status := extractStatus(idStatus)
+
+		if status == counterStatusNotUsed {
+			break
+		}
+
+		if counterID == extractID(idStatus) {
+			if status != counterStatusAllocated {
+				return fmt.Errorf("counter %d isn't allocated", counterID)
+			}
+			if !d.hasValue(valueOffset) {
+				return fmt.Errorf("counter %d has no value in the values' region", counterID)
+			}
+			values.PutInt64Volatile(uintptr(valueOffset), value)
+			return nil
+		}
+
+		metadataOffset += metadataRecordLength
+		valueOffset += d.valueStride
+	}
+
+	return fmt.Errorf("counter %d not found", counterID)
+}
+
 // GetCounterLabel returns
 func (d *Decoder) GetCounterLabel(counterID int64) (label string, err error) {
 	metadata := d.Layout.CountersMetadata
@@ -215,6 +495,8 @@ func (d *Decoder) GetCounterLabel(counterID int64) (label string, err error) {
 	for metadataOffset < metadata.Capacity() {
 		idStatusOffset := metadataOffset + metadataCounterIDStatusOffset
 
+		generation := d.generation(metadataOffset)
+
 		idStatus := metadata.GetInt64Volatile(uintptr(idStatusOffset))
 
 		status := extractStatus(idStatus)
@@ -234,7 +516,7 @@ func (d *Decoder) GetCounterLabel(counterID int64) (label string, err error) {
 
 				// Make sure the counter's status wasn't changed yet to guarantee
 				// the value just read belongs to this counter.
-				if metadata.GetInt64Volatile(uintptr(idStatusOffset)) == idStatus {
+				if d.unchanged(metadataOffset, idStatus, generation) {
 					return string(labelBytes), nil
 				}
 				continue
@@ -249,3 +531,47 @@ func (d *Decoder) GetCounterLabel(counterID int64) (label string, err error) {
 
 	return "", fmt.Errorf("counter %d not found", counterID)
 }
+
+// GetCounterType returns
+func (d *Decoder) GetCounterType(counterID int64) (counterType CounterType, err error) {
+	metadata := d.Layout.CountersMetadata
+
+	metadataOffset := 0
+
+	for metadataOffset < metadata.Capacity() {
+		idStatusOffset := metadataOffset + metadataCounterIDStatusOffset
+
+		generation := d.generation(metadataOffset)
+
+		idStatus := metadata.GetInt64Volatile(uintptr(idStatusOffset))
+
+		status := extractStatus(idStatus)
+
+		if status == counterStatusNotUsed {
+			break
+		}
+
+		id := extractID(idStatus)
+
+		if counterID == id {
+			switch status {
+			case counterStatusAllocated:
+				counterType = CounterType(metadata.GetInt32(uintptr(metadataOffset + metadataCounterTypeOffset)))
+
+				// Make sure the counter's status wasn't changed yet to guarantee
+				// the type just read belongs to this counter.
+				if d.unchanged(metadataOffset, idStatus, generation) {
+					return counterType, nil
+				}
+				continue
+
+			default:
+				return 0, fmt.Errorf("counter %d isn't allocated", counterID)
+			}
+		}
+
+		metadataOffset += metadataRecordLength
+	}
+
+	return 0, fmt.Errorf("counter %d not found", counterID)
+}
@@ -4,12 +4,21 @@
 package layout
 
 import (
+	"fmt"
+
 	"github.com/anatolygudkov/mc4go/internal/offheap"
 )
 
 // CountersVersion presents
 const CountersVersion = 1
 
+// CountersVersionExtended is the version of the files using the features, which readers of
+// CountersVersion would misread. Such readers refuse these files.
+const CountersVersionExtended = 2
+
+// MaxCountersVersion is the newest version of the files supported.
+const MaxCountersVersion = CountersVersionExtended
+
 const sizeOfInt32 = 4
 const sizeOfInt64 = 8
 const sizeOfCacheLine = 64
@@ -17,6 +26,14 @@ const sizeOfCacheLine = 64
 /**
  * Layout of the counters.
  *
+ * The layout follows the one of mc4j (https://github.com/anatolygudkov/mc4j) as it's described
+ * below; there is no test against files written by mc4j, so the compatibility isn't verified.
+ * All numbers are stored in the native byte order. Extensions live in the padding, which
+ * is zero otherwise: the counter's type in the metadata, zero is CounterTypeInt64; the value
+ * stride in the header, zero is the default stride. Files using the extensions an older reader
+ * would misread have CountersVersionExtended. The features in the header tell which extensions
+ * the file uses.
+ *
  * Header
  *
  *   0                   1                   2                   3
@@ -36,7 +53,14 @@ const sizeOfCacheLine = 64
  *  |                      Start time millis                        |
  *  |                                                               |
  *  +---------------------------------------------------------------+
- *  |                     96 bytes of padding                      ...
+ *  |            Value stride, 0 means 128 bytes per value          |
+ *  +---------------------------------------------------------------+
+ *  |                  Features, a bitfield of Feature*             |
+ *  +---------------------------------------------------------------+
+ *  |           Epoch, incremented on discontinuities of values     |
+ *  |                                                               |
+ *  +---------------------------------------------------------------+
+ *  |                     80 bytes of padding                      ...
  * ...                                                              |
  *  +---------------------------------------------------------------+
  *
@@ -78,7 +102,14 @@ const sizeOfCacheLine = 64
  *  |                Counter[0]'s ID << 8 | Status                  |
  *  |                                                               |
  *  +---------------------------------------------------------------+
- *  |                     120 bytes of padding                     ...
+ *  |                       Counter[0]'s type                       |
+ *  +---------------------------------------------------------------+
+ *  |                      4 bytes of padding                       |
+ *  +---------------------------------------------------------------+
+ *  |   Counter[0]'s generation, incremented on each allocation     |
+ *  |                                                               |
+ *  +---------------------------------------------------------------+
+ *  |                     104 bytes of padding                     ...
  * ...                                                              |
  *  +---------------------------------------------------------------+
  *  |                  Counters[0]'s label length                   |
@@ -100,7 +131,11 @@ const sizeOfCacheLine = 64
  *  |                       Counter[0]'s value                      |
  *  |                                                               |
  *  +---------------------------------------------------------------+
- *  |                     120 bytes of padding                     ...
+ *  |     Min, max and count of the recorded values, if the type    |
+ *  |        is CounterTypeMinMax, otherwise they're padding       ...
+ * ...                          24 bytes                            |
+ *  +---------------------------------------------------------------+
+ *  |  Value stride - 32 bytes of padding, 96 by default           ...
  * ...                                                              |
  *  +---------------------------------------------------------------+
  *  |              Repeats for Counter[1]-Counter[N]               ...
@@ -116,6 +151,28 @@ type Layout struct {
 	CountersValues   *offheap.Buffer
 }
 
+// Alignment is the alignment of the sections required for atomic access to 64-bit numbers.
+// Unlike amd64, 32-bit platforms, for example, 386 and arm, panic on unaligned atomic access.
+const Alignment = sizeOfInt64
+
+// CheckAlignment returns an error if a section of the layout isn't aligned on Alignment bytes.
+func (l *Layout) CheckAlignment() error {
+	for _, section := range []struct {
+		name string
+		buf  *offheap.Buffer
+	}{
+		{"header", l.Header},
+		{"statics", l.Statics},
+		{"metadata", l.CountersMetadata},
+		{"values", l.CountersValues},
+	} {
+		if addr := section.buf.Address(); addr%Alignment != 0 {
+			return fmt.Errorf("%s of the counters isn't aligned on %d bytes: 0x%x", section.name, Alignment, addr)
+		}
+	}
+	return nil
+}
+
 const (
 	headerCountersVersionOffset = 0
 	headerStaticsLengthOffset   = headerCountersVersionOffset + sizeOfInt32
@@ -123,10 +180,44 @@ const (
 	headerValuesLengthOffset    = headerMetadataLengthOffset + sizeOfInt32
 	headerPidOffsert            = headerValuesLengthOffset + sizeOfInt32
 	headerStartTimeOffsert      = headerPidOffsert + sizeOfInt64
+	headerValueStrideOffset     = headerStartTimeOffsert + sizeOfInt64
+	headerFeaturesOffset        = headerValueStrideOffset + sizeOfInt32
+	headerEpochOffset           = headerFeaturesOffset + sizeOfInt32
 )
 
 func HeaderLength() int {
-	return Align(headerStartTimeOffsert+sizeOfInt64, sizeOfCacheLine*2)
+	return Align(headerEpochOffset+sizeOfInt64, sizeOfCacheLine*2)
+}
+
+// Features of the file's format stored in the header. Files of mc4j and of the versions
+// before the features were introduced have zero there.
+const (
+	// FeatureBigEndian is set if the numbers are stored in the big endian byte order.
+	FeatureBigEndian uint32 = 1 << iota
+	// FeatureValueStride is set if the value stride isn't the default one.
+	FeatureValueStride
+	// FeatureFloatCounters is set once a float counter is added.
+	FeatureFloatCounters
+	// FeatureExplicitIDs is set if IDs of the counters are reserved to be set explicitly.
+	FeatureExplicitIDs
+	// FeatureMinMaxCounters is set once a min/max counter is added.
+	FeatureMinMaxCounters
+	// FeatureReservedSlots is set once a slot is reserved for a counter.
+	FeatureReservedSlots
+)
+
+// KnownFeatures are all the features supported. Files with other features are refused.
+const KnownFeatures = FeatureReservedSlots<<1 - 1
+
+// ExtendedFeatures are the features requiring CountersVersionExtended.
+const ExtendedFeatures = FeatureValueStride | FeatureMinMaxCounters | FeatureReservedSlots
+
+// VersionOf returns the version of a file with the features.
+func VersionOf(features uint32) int32 {
+	if features&ExtendedFeatures != 0 {
+		return CountersVersionExtended
+	}
+	return CountersVersion
 }
 
 const (
@@ -148,18 +239,61 @@ func staticsRecordLength(labelLength int, valueLength int) int {
 const (
 	metadataLabelMaxLength        = sizeOfCacheLine*6 - sizeOfInt32 // max length of the label's text without its length prefix
 	metadataCounterIDStatusOffset = 0
+	metadataCounterTypeOffset     = metadataCounterIDStatusOffset + sizeOfInt64
+	metadataGenerationOffset      = metadataCounterTypeOffset + sizeOfInt64 // aligned on 8 bytes
 	metadataLabelLengthOffset     = sizeOfCacheLine * 2
 	metadataLabelOffset           = metadataLabelLengthOffset + sizeOfInt32
 	metadataRecordLength          = metadataLabelOffset + metadataLabelMaxLength
 )
 
+// MaxLabelLength is the max number of bytes of a counter's label. Longer labels are truncated.
+const MaxLabelLength = metadataLabelMaxLength
+
 const valuesCounterLength = sizeOfCacheLine * 2
 
+// DefaultValueStride is the number of bytes occupied by a counter's value. The padding
+// prevents false sharing between counters modified by different threads.
+const DefaultValueStride = valuesCounterLength
+
+// ValidateValueStride returns an error if values can't be stored with the stride specified.
+// The stride must be a power of 2 between 8 and DefaultValueStride.
+func ValidateValueStride(stride int) error {
+	if stride < sizeOfInt64 || stride > DefaultValueStride || stride&(stride-1) != 0 {
+		return fmt.Errorf("incorrect value stride: %d", stride)
+	}
+	return nil
+}
+
+// CounterType defines how a counter's value is encoded in its value slot.
+type CounterType int32
+
+const (
+	// CounterTypeInt64 is a counter with int64 value.
+	CounterTypeInt64 CounterType = 0
+	// CounterTypeFloat64 is a counter with float64 value stored as its IEEE 754 bits.
+	CounterTypeFloat64 CounterType = 1
+	// CounterTypeMinMax is a counter with int64 value, which also tracks the min, the max
+	// and the count of the recorded values in its value slot.
+	CounterTypeMinMax CounterType = 2
+)
+
+// Offsets of the min, the max and the count of a CounterTypeMinMax counter from its value.
+// Before the first value is recorded, the min is math.MaxInt64 and the max is math.MinInt64.
+const (
+	ValueMinOffset   = sizeOfInt64
+	ValueMaxOffset   = ValueMinOffset + sizeOfInt64
+	ValueCountOffset = ValueMaxOffset + sizeOfInt64
+)
+
+// MinMaxValueStride is the min value stride allowing CounterTypeMinMax counters.
+const MinMaxValueStride = ValueCountOffset + sizeOfInt64
+
 const (
 	counterStatusNotUsed              uint8 = 0
 	counterStatusAllocationInProgress uint8 = 1
 	counterStatusAllocated            uint8 = 2
 	counterStatusFreed                uint8 = 3
+	counterStatusReserved             uint8 = 4 // claimed by the writer for a counter to be added later
 )
 
 func makeIDStatus(id int64, status uint8) int64 {
@@ -0,0 +1,286 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package layout
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+
+	"github.com/anatolygudkov/mc4go/internal/offheap"
+)
+
+// TestOnDiskFormat pins the byte offsets described in layout.go. It expects a little endian platform.
+func TestOnDiskFormat(t *testing.T) {
+	if HeaderLength() != 128 {
+		t.Fatalf("Header length %d, expected %d", HeaderLength(), 128)
+	}
+	if metadataRecordLength != 512 {
+		t.Fatalf("Metadata record length %d, expected %d", metadataRecordLength, 512)
+	}
+	if metadataLabelMaxLength != 380 {
+		t.Fatalf("Max label length %d, expected %d", metadataLabelMaxLength, 380)
+	}
+	if valuesCounterLength != 128 {
+		t.Fatalf("Value slot length %d, expected %d", valuesCounterLength, 128)
+	}
+
+	statics := map[string]string{"ab": "cde"}
+	staticsLength := StaticsLength(statics)
+	if staticsLength != 128 {
+		t.Fatalf("Statics length %d, expected %d", staticsLength, 128)
+	}
+	metadataLength := MetadataLength(1)
+	valuesLength := ValuesLength(1)
+
+	size := HeaderLength() + staticsLength + metadataLength + valuesLength
+	words := make([]int64, size/8)
+	b := (*[1 << 20]byte)(unsafe.Pointer(&words[0]))[:size:size]
+
+	e := NewEncoder(offheap.NewBufferFromSlice(b), staticsLength, metadataLength, valuesLength)
+	e.SetPid(12345)
+	e.SetStartTime(67890)
+	if err := e.SetStatics(statics); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddCounter(7, 42, "cnt"); err != nil {
+		t.Fatal(err)
+	}
+	e.SetVersion(CountersVersion)
+
+	expectInt32 := func(offset int, expected int32) {
+		if v := int32(binary.LittleEndian.Uint32(b[offset:])); v != expected {
+			t.Fatalf("Offset %d: %d, expected %d", offset, v, expected)
+		}
+	}
+	expectInt64 := func(offset int, expected int64) {
+		if v := int64(binary.LittleEndian.Uint64(b[offset:])); v != expected {
+			t.Fatalf("Offset %d: %d, expected %d", offset, v, expected)
+		}
+	}
+	expectString := func(offset int, expected string) {
+		if v := string(b[offset : offset+len(expected)]); v != expected {
+			t.Fatalf("Offset %d: '%s', expected '%s'", offset, v, expected)
+		}
+	}
+
+	// Header
+	expectInt32(0, CountersVersion)
+	expectInt32(4, int32(staticsLength))
+	expectInt32(8, int32(metadataLength))
+	expectInt32(12, int32(valuesLength))
+	expectInt64(16, 12345)
+	expectInt64(24, 67890)
+	expectInt32(32, 0) // the default value stride
+	expectInt32(36, 0) // no features
+	expectInt64(40, 0) // the initial epoch
+
+	// Statics
+	statx := 128
+	expectInt32(statx, 1)
+	expectInt32(statx+4, 2)
+	expectInt32(statx+8, 3)
+	expectString(statx+12, "abcde")
+
+	// Metadata
+	metadata := statx + staticsLength
+	expectInt64(metadata, 7<<8|int64(counterStatusAllocated))
+	expectInt32(metadata+8, int32(CounterTypeInt64))
+	expectInt32(metadata+128, 3)
+	expectString(metadata+132, "cnt")
+
+	// Values
+	expectInt64(metadata+metadataLength, 42)
+}
+
+func TestTruncatedValues(t *testing.T) {
+	staticsLength := StaticsLength(nil)
+	metadataLength := MetadataLength(3)
+	valuesLength := ValuesLength(3)
+
+	b := make([]byte, HeaderLength()+staticsLength+metadataLength+valuesLength)
+	e := NewEncoder(offheap.NewBufferFromSlice(b), staticsLength, metadataLength, valuesLength)
+	for id := int64(0); id < 3; id++ {
+		if _, err := e.AddCounter(id, id+10, "cnt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.SetVersion(CountersVersion)
+
+	// The values' region is sized for one counter only
+	l := e.Layout
+	values := offheap.NewBufferFromSlice(l.CountersValues.GetBytes(0, DefaultValueStride))
+	d := NewDecoderWithBuffers(l.Header, l.Statics, l.CountersMetadata, values)
+
+	var ids []int64
+	d.ForEachCounter(func(id, value int64, label string) bool {
+		if value != id+10 {
+			t.Fatalf("Got value %d for counter %d, expected %d", value, id, id+10)
+		}
+		ids = append(ids, id)
+		return true
+	})
+	if len(ids) != 1 || ids[0] != 0 {
+		t.Fatalf("Got counters %v, expected [0]", ids)
+	}
+
+	if v, err := d.GetCounterValue(0); err != nil || v != 10 {
+		t.Fatalf("Got %d (%v), expected 10", v, err)
+	}
+	if _, err := d.GetCounterValue(2); err == nil {
+		t.Fatal("A counter without a value must not be read")
+	}
+	if err := d.SetCounterValue(2, 1); err == nil {
+		t.Fatal("A counter without a value must not be written")
+	}
+	slot, err := d.FindCounter(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.GetCounterValueAt(slot); ok {
+		t.Fatal("A counter without a value must not be read by its slot")
+	}
+}
+
+func TestSlotIDStatus(t *testing.T) {
+	staticsLength := StaticsLength(nil)
+	metadataLength := MetadataLength(3)
+	valuesLength := ValuesLength(3)
+
+	b := make([]byte, HeaderLength()+staticsLength+metadataLength+valuesLength)
+	e := NewEncoder(offheap.NewBufferFromSlice(b), staticsLength, metadataLength, valuesLength)
+	for _, id := range []int64{5, 7} {
+		if _, err := e.AddCounter(id, 0, "cnt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.FreeCounter(7)
+	e.SetVersion(CountersVersion)
+
+	d := NewDecoder(offheap.NewBufferFromSlice(b))
+	for index, expected := range []struct {
+		id     int64
+		status uint8
+	}{{5, counterStatusAllocated}, {7, counterStatusFreed}, {0, counterStatusNotUsed}} {
+		id, status, ok := d.GetSlotIDStatus(index)
+		if !ok || id != expected.id || status != expected.status {
+			t.Fatalf("Slot %d: got %d/%d (%v), expected %d/%d", index, id, status, ok, expected.id, expected.status)
+		}
+	}
+
+	for _, index := range []int{-1, 3} {
+		if _, _, ok := d.GetSlotIDStatus(index); ok {
+			t.Fatalf("No slot %d expected", index)
+		}
+	}
+}
+
+func TestForEachTypedCounterLive(t *testing.T) {
+	staticsLength := StaticsLength(nil)
+	metadataLength := MetadataLength(3)
+	valuesLength := ValuesLength(3)
+
+	b := make([]byte, HeaderLength()+staticsLength+metadataLength+valuesLength)
+	e := NewEncoder(offheap.NewBufferFromSlice(b), staticsLength, metadataLength, valuesLength)
+	e.SetVersion(CountersVersion)
+
+	// The second slot is claimed before the first one, as a concurrent allocation may do
+	if !e.allocate(metadataRecordLength, DefaultValueStride, 0, 9, CounterTypeInt64, 90, "cnt") {
+		t.Fatal("The slot must be free")
+	}
+
+	d := NewDecoder(offheap.NewBufferFromSlice(b))
+
+	count := func(forEach func(consumer func(id int64, counterType CounterType, value int64, label string) bool)) (ids []int64) {
+		forEach(func(id int64, counterType CounterType, value int64, label string) bool {
+			ids = append(ids, id)
+			return true
+		})
+		return ids
+	}
+
+	if ids := count(d.ForEachTypedCounter); len(ids) != 0 {
+		t.Fatalf("Got %v, the scan stops at the first never used slot", ids)
+	}
+	if ids := count(d.ForEachTypedCounterLive); len(ids) != 1 || ids[0] != 9 {
+		t.Fatalf("Got %v, expected [9]", ids)
+	}
+}
+
+func TestSlotReusedBySameID(t *testing.T) {
+	staticsLength := StaticsLength(nil)
+	metadataLength := MetadataLength(2)
+	valuesLength := ValuesLength(2)
+
+	b := make([]byte, HeaderLength()+staticsLength+metadataLength+valuesLength)
+	e := NewEncoder(offheap.NewBufferFromSlice(b), staticsLength, metadataLength, valuesLength)
+	e.SetVersion(CountersVersion)
+
+	if _, err := e.AddTypedCounterUnique(5, CounterTypeInt64, 1, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(offheap.NewBufferFromSlice(b))
+	slot, err := d.FindCounter(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.FreeCounter(5)
+	if _, err := e.AddTypedCounterUnique(5, CounterTypeInt64, 2, "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The id/status word is the same again, but the slot holds another allocation
+	reused, err := d.FindCounter(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused.Index != slot.Index || reused.idStatus != slot.idStatus {
+		t.Fatalf("Got slot %+v, expected the slot %+v reused", reused, slot)
+	}
+	if _, ok := d.GetCounterValueAt(slot); ok {
+		t.Fatal("The value of the previous allocation must not be read")
+	}
+	if v, ok := d.GetCounterValueAt(reused); !ok || v != 2 {
+		t.Fatalf("Got %d (%v), expected 2", v, ok)
+	}
+
+	// A record read across the reallocation isn't consistent
+	metadataOffset := slot.Index * metadataRecordLength
+	generation := d.generation(metadataOffset)
+	e.FreeCounter(5)
+	if _, err := e.AddTypedCounterUnique(5, CounterTypeInt64, 3, "third"); err != nil {
+		t.Fatal(err)
+	}
+	if d.unchanged(metadataOffset, reused.idStatus, generation) {
+		t.Fatal("The reallocation isn't detected")
+	}
+}
+
+func TestVersionOfFeatures(t *testing.T) {
+	metadataLength := MetadataLength(2)
+	size := HeaderLength() + metadataLength + ValuesLength(2)
+	words := make([]int64, size/8)
+	b := (*[1 << 20]byte)(unsafe.Pointer(&words[0]))[:size:size]
+
+	e := NewEncoder(offheap.NewBufferFromSlice(b), 0, metadataLength, ValuesLength(2))
+	if v := e.RequiredVersion(); v != CountersVersion {
+		t.Fatalf("Version %d, expected %d", v, CountersVersion)
+	}
+	e.SetVersion(e.RequiredVersion())
+	e.AddFeatures(FeatureFloatCounters)
+	if v := NewDecoder(offheap.NewBufferFromSlice(b)).Version(); v != CountersVersion {
+		t.Fatalf("Version %d, expected %d", v, CountersVersion)
+	}
+
+	size = HeaderLength() + metadataLength + ValuesLengthWithStride(2, 8)
+	words = make([]int64, size/8)
+	b = (*[1 << 20]byte)(unsafe.Pointer(&words[0]))[:size:size]
+
+	e = NewEncoder(offheap.NewBufferFromSlice(b), 0, metadataLength, ValuesLengthWithStride(2, 8))
+	if v := e.RequiredVersion(); v != CountersVersionExtended {
+		t.Fatalf("Version %d, expected %d", v, CountersVersionExtended)
+	}
+}
@@ -4,14 +4,27 @@
 package mmap
 
 import (
+	"errors"
+	"log"
 	"os"
 	"path"
+	"runtime"
 
 	"github.com/anatolygudkov/mc4go/internal/offheap"
 )
 
 // MapNewFile My func
 func MapNewFile(filename string, size int) (buf *offheap.Buffer, err error) {
+	buf, f, err := mapNewFile(filename, size)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return buf, nil
+}
+
+// mapNewFile creates the file, maps it and returns the mapped buffer and the open file.
+func mapNewFile(filename string, size int) (buf *offheap.Buffer, f *os.File, err error) {
 	pageSize := os.Getpagesize()
 
 	alignedSize := align(size, pageSize)
@@ -19,23 +32,24 @@ func MapNewFile(filename string, size int) (buf *offheap.Buffer, err error) {
 	dir := path.Dir(filename)
 	os.MkdirAll(dir, os.ModePerm)
 
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	f, err = os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer f.Close()
 
-	err = os.Truncate(f.Name(), int64(alignedSize))
+	err = f.Truncate(int64(alignedSize))
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, nil, err
 	}
 
 	addr, _, err := mmap(f, false)
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, nil, err
 	}
 
-	buf = offheap.NewBuffer(addr, alignedSize)
+	buf = newMappedBuffer(addr, alignedSize)
 
 	// Now pre-touch all the pages.
 	position := 0
@@ -44,30 +58,164 @@ func MapNewFile(filename string, size int) (buf *offheap.Buffer, err error) {
 		position += pageSize
 	}
 
-	return buf, nil
+	return buf, f, nil
 }
 
 // MapExistingFileReadOnly maps
 func MapExistingFileReadOnly(filename string) (buf *offheap.Buffer, err error) {
-	file, err := os.Open(filename)
+	buf, _, err = MapExistingFileReadOnlyWithInfo(filename)
+	return buf, err
+}
+
+// MapExistingFileReadOnlyWithInfo maps the file as MapExistingFileReadOnly does
+// and returns the info of the file mapped, which may be compared with os.SameFile.
+func MapExistingFileReadOnlyWithInfo(filename string) (buf *offheap.Buffer, fi os.FileInfo, err error) {
+	return mapExistingFile(filename, os.O_RDONLY, true)
+}
+
+// MapExistingFile maps an existing file for reading and writing.
+func MapExistingFile(filename string) (buf *offheap.Buffer, err error) {
+	buf, _, err = MapExistingFileWithInfo(filename)
+	return buf, err
+}
+
+// MapExistingFileWithInfo maps the file as MapExistingFile does and returns the info of the file mapped.
+func MapExistingFileWithInfo(filename string) (buf *offheap.Buffer, fi os.FileInfo, err error) {
+	return mapExistingFile(filename, os.O_RDWR, false)
+}
+
+func mapExistingFile(filename string, flag int, readOnly bool) (buf *offheap.Buffer, fi os.FileInfo, err error) {
+	buf, file, err := mapExistingFileOpen(filename, flag, readOnly)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer file.Close()
 
-	addr, size, err := mmap(file, true)
+	fi, err = file.Stat()
+	if err != nil {
+		Unmap(buf)
+		return nil, nil, err
+	}
+
+	return buf, fi, nil
+}
+
+// mapExistingFileOpen maps the file and returns the mapped buffer and the open file.
+func mapExistingFileOpen(filename string, flag int, readOnly bool) (buf *offheap.Buffer, file *os.File, err error) {
+	file, err = os.OpenFile(filename, flag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addr, size, err := mmap(file, readOnly)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return newMappedBuffer(addr, size), file, nil
+}
+
+// Mapping is a mapped file which is kept open while it's mapped, so the file can be used
+// for syncing, locking or resizing. It's closed by Unmap.
+type Mapping struct {
+	buf  *offheap.Buffer
+	file *os.File
+}
+
+// MapNewFileRetained creates and maps the file as MapNewFile does, but keeps the file open.
+func MapNewFileRetained(filename string, size int) (m *Mapping, err error) {
+	buf, f, err := mapNewFile(filename, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{buf: buf, file: f}, nil
+}
+
+// MapExistingFileRetained maps an existing file as MapExistingFile or MapExistingFileReadOnly do,
+// but keeps the file open.
+func MapExistingFileRetained(filename string, readOnly bool) (m *Mapping, err error) {
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	buf, f, err := mapExistingFileOpen(filename, flag, readOnly)
 	if err != nil {
 		return nil, err
 	}
+	return &Mapping{buf: buf, file: f}, nil
+}
+
+// Buffer returns the mapped buffer.
+func (m *Mapping) Buffer() *offheap.Buffer {
+	return m.buf
+}
 
-	return offheap.NewBuffer(addr, size), nil
+// File returns the mapped file. It's closed by Unmap.
+func (m *Mapping) File() *os.File {
+	return m.file
+}
+
+// Sync writes modified pages of the mapping to the file and commits the file to the disk.
+func (m *Mapping) Sync() (err error) {
+	if m.buf == nil {
+		return errors.New("the file is unmapped")
+	}
+	if err := Flush(m.buf); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+// Unmap unmaps the buffer and closes the file. The first error is returned.
+func (m *Mapping) Unmap() (err error) {
+	if m.buf == nil {
+		return errors.New("the file is unmapped")
+	}
+	err = Unmap(m.buf)
+	if closeErr := m.file.Close(); err == nil {
+		err = closeErr
+	}
+	m.buf = nil
+	return err
 }
 
 // Unmap unpams
 func Unmap(buf *offheap.Buffer) (err error) {
+	runtime.SetFinalizer(buf, nil)
 	return munmap(buf.Address(), buf.Capacity())
 }
 
+// Prefetch reads a word of each page of the buffer, so the pages are faulted in before they're accessed.
+// It returns the sum of the words read, which keeps the reads from being optimized away.
+func Prefetch(buf *offheap.Buffer) (sum int64) {
+	pageSize := os.Getpagesize()
+	for position := 0; position+8 <= buf.Capacity(); position += pageSize {
+		sum += buf.GetInt64(uintptr(position))
+	}
+	return sum
+}
+
+// Flush writes modified pages of the buffer to the mapped file synchronously.
+func Flush(buf *offheap.Buffer) (err error) {
+	return msync(buf.Address(), buf.Capacity())
+}
+
+// newMappedBuffer creates a buffer which is unmapped when it becomes unreachable,
+// if Unmap wasn't called. This is a safety net for forgotten Close only.
+func newMappedBuffer(addr uintptr, size int) *offheap.Buffer {
+	buf := offheap.NewBuffer(addr, size)
+	runtime.SetFinalizer(buf, unmapLeaked)
+	return buf
+}
+
+func unmapLeaked(buf *offheap.Buffer) {
+	log.Printf("mmap: unmapping leaked mapping of %d bytes at 0x%x, Close wasn't called", buf.Capacity(), buf.Address())
+	if err := munmap(buf.Address(), buf.Capacity()); err != nil {
+		log.Printf("mmap: %v", err)
+	}
+}
+
 // align rounds v up to alignment multiple of alignment. alignment must be a power of 2.
 func align(v int, alignment int) int {
 	return (v + alignment - 1) &^ (alignment - 1)
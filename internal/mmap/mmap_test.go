@@ -2,3 +2,66 @@
 // Use of this source code is governed by MIT license
 // that can be found in the LICENSE file.
 package mmap
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/anatolygudkov/mc4go/internal/offheap"
+)
+
+func TestMappingRetained(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestMmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "mapped.dat")
+
+	m, err := MapNewFileRetained(filename, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Buffer().Capacity() != os.Getpagesize() {
+		t.Fatalf("Got capacity %d, expected %d", m.Buffer().Capacity(), os.Getpagesize())
+	}
+
+	m.Buffer().PutInt64(8, 42)
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := offheap.NewBufferFromSlice(b).GetInt64(8); v != 42 {
+		t.Fatalf("Got %d in the file, expected 42", v)
+	}
+
+	f := m.File()
+	if err := m.Unmap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Stat(); err == nil {
+		t.Fatal("The file must be closed by Unmap")
+	}
+	if err := m.Sync(); err == nil {
+		t.Fatal("Sync of the unmapped file must fail")
+	}
+	if err := m.Unmap(); err == nil {
+		t.Fatal("The file is unmapped already")
+	}
+
+	m, err = MapExistingFileRetained(filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Unmap()
+	if v := m.Buffer().GetInt64(8); v != 42 {
+		t.Fatalf("Got %d, expected 42", v)
+	}
+}
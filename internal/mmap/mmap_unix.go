@@ -1,7 +1,10 @@
 // Copyright (c) 2020 anatolygudkov. All rights reserved.
 // Use of this source code is governed by MIT license
 // that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !solaris && !aix
 // +build !windows,!plan9,!solaris,!aix
+
 package mmap
 
 import (
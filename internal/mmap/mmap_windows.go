@@ -49,3 +49,11 @@ func munmap(addr uintptr) (err error) {
 	}
 	return nil
 }
+
+// msync flushes the mapped memory to the file
+func msync(addr uintptr, size int) (err error) {
+	if err := syscall.FlushViewOfFile(addr, uintptr(size)); err != nil {
+		return os.NewSyscallError("FlushViewOfFile", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mmap
+
+import (
+	"errors"
+)
+
+// msync isn't available in the syscall package for netbsd
+func msync(addr uintptr, size int) (err error) {
+	return errors.New("flushing mapped memory isn't supported on netbsd")
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !solaris && !aix && !netbsd
+// +build !windows,!plan9,!solaris,!aix,!netbsd
+
+package mmap
+
+import (
+	"syscall"
+)
+
+// msync flushes the mapped memory to the file
+func msync(addr uintptr, size int) (err error) {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, addr, uintptr(size), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
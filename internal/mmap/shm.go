@@ -0,0 +1,48 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mmap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anatolygudkov/mc4go/internal/offheap"
+)
+
+// MapNewSharedMemory creates a new named shared memory segment and maps it.
+// The name follows shm_open conventions: an optional leading slash and no other slashes.
+func MapNewSharedMemory(name string, size int) (buf *offheap.Buffer, err error) {
+	p, err := sharedMemoryPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return MapNewFile(p, size)
+}
+
+// MapExistingSharedMemoryReadOnly maps an existing named shared memory segment for reading only.
+func MapExistingSharedMemoryReadOnly(name string) (buf *offheap.Buffer, err error) {
+	p, err := sharedMemoryPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return MapExistingFileReadOnly(p)
+}
+
+// UnlinkSharedMemory removes the name of a shared memory segment.
+// The segment itself lives until all its mappings are unmapped.
+func UnlinkSharedMemory(name string) (err error) {
+	p, err := sharedMemoryPath(name)
+	if err != nil {
+		return err
+	}
+	return removeSharedMemory(p)
+}
+
+func validateSharedMemoryName(name string) (n string, err error) {
+	n = strings.TrimPrefix(name, "/")
+	if n == "" || strings.ContainsRune(n, '/') || n == "." || n == ".." {
+		return "", fmt.Errorf("invalid shared memory name: '%s'", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mmap
+
+import (
+	"os"
+	"path"
+)
+
+// sharedMemoryDir is where glibc's shm_open creates shared memory segments.
+const sharedMemoryDir = "/dev/shm"
+
+func sharedMemoryPath(name string) (p string, err error) {
+	n, err := validateSharedMemoryName(name)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(sharedMemoryDir, n), nil
+}
+
+func removeSharedMemory(p string) (err error) {
+	return os.Remove(p)
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package mmap
+
+import (
+	"errors"
+)
+
+var errSharedMemoryNotSupported = errors.New("shared memory isn't supported on this platform")
+
+func sharedMemoryPath(name string) (p string, err error) {
+	return "", errSharedMemoryNotSupported
+}
+
+func removeSharedMemory(p string) (err error) {
+	return errSharedMemoryNotSupported
+}
@@ -4,27 +4,44 @@
 package offheap
 
 import (
+	"fmt"
+	"io"
+	"math"
 	"sync/atomic"
 	"unsafe"
 )
 
 // Buffer is a...
 type Buffer struct {
-	addr     uintptr
+	ptr      unsafe.Pointer // a pointer, not an uintptr, keeps Go memory the buffer is created over reachable
 	capacity int
+	parent   *Buffer // keeps the buffer the slice is taken from reachable
 }
 
 // NewBuffer creates
 func NewBuffer(addr uintptr, capacity int) *Buffer {
+	return newBuffer(unsafe.Pointer(addr), capacity)
+}
+
+func newBuffer(ptr unsafe.Pointer, capacity int) *Buffer {
 	return &Buffer{
-		addr:     addr,
+		ptr:      ptr,
 		capacity: capacity,
 	}
 }
 
+// NewBufferFromSlice creates a buffer over the backing array of the slice. The array is
+// kept reachable while the buffer and the slices taken from it are in use.
+func NewBufferFromSlice(b []byte) *Buffer {
+	if len(b) == 0 {
+		return NewBuffer(0, 0)
+	}
+	return newBuffer(unsafe.Pointer(&b[0]), len(b))
+}
+
 // Address returns
 func (b *Buffer) Address() uintptr {
-	return b.addr
+	return uintptr(b.ptr)
 }
 
 // Capacity returns
@@ -34,62 +51,243 @@ func (b *Buffer) Capacity() int {
 
 // Slice returns
 func (b *Buffer) Slice(offset uintptr, capacity int) *Buffer {
-	return NewBuffer(b.addr+offset, capacity)
+	s := newBuffer(b.at(offset), capacity)
+	s.parent = b
+	return s
+}
+
+// at returns the pointer to the byte at the offset.
+func (b *Buffer) at(offset uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(b.ptr) + offset)
+}
+
+// nativeBigEndian is true if the native byte order is big endian.
+var nativeBigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+// IsNativeBigEndian returns true if numbers are stored in the big endian byte order on this platform.
+func IsNativeBigEndian() bool {
+	return nativeBigEndian
+}
+
+// GetInt8 returns
+func (b *Buffer) GetInt8(offset uintptr) int8 {
+	return *(*int8)(b.at(offset))
+}
+
+// PutInt8 sets
+func (b *Buffer) PutInt8(offset uintptr, v int8) {
+	*(*int8)(b.at(offset)) = v
+}
+
+// GetInt8Volatile returns the byte by loading the aligned int32 containing it.
+// The buffer must be aligned on 4 bytes.
+func (b *Buffer) GetInt8Volatile(offset uintptr) int8 {
+	return int8(b.getBitsVolatile(offset, 1))
+}
+
+// PutInt8Volatile atomically sets the byte within the aligned int32 containing it
+// without touching the neighbouring bytes. The buffer must be aligned on 4 bytes.
+func (b *Buffer) PutInt8Volatile(offset uintptr, v int8) {
+	b.putBitsVolatile(offset, 1, uint32(uint8(v)))
+}
+
+// GetInt16 returns
+func (b *Buffer) GetInt16(offset uintptr) int16 {
+	return *(*int16)(b.at(offset))
+}
+
+// PutInt16 sets
+func (b *Buffer) PutInt16(offset uintptr, v int16) {
+	*(*int16)(b.at(offset)) = v
+}
+
+// GetInt16Volatile returns the int16 by loading the aligned int32 containing it.
+// The buffer must be aligned on 4 bytes and the offset on 2 bytes.
+func (b *Buffer) GetInt16Volatile(offset uintptr) int16 {
+	return int16(b.getBitsVolatile(offset, 2))
+}
+
+// PutInt16Volatile atomically sets the int16 within the aligned int32 containing it
+// without touching the neighbouring bytes. The buffer must be aligned on 4 bytes
+// and the offset on 2 bytes.
+func (b *Buffer) PutInt16Volatile(offset uintptr, v int16) {
+	b.putBitsVolatile(offset, 2, uint32(uint16(v)))
+}
+
+// word returns the offset of the aligned int32 containing width bytes at the offset
+// and the shift of the bytes within the int32's value.
+func (b *Buffer) word(offset uintptr, width uintptr) (wordOffset uintptr, shift uint) {
+	index := uintptr(b.at(offset)) % 4
+	if index%width != 0 {
+		panic("offheap: unaligned access")
+	}
+	wordOffset = offset - index
+	if nativeBigEndian {
+		index = 4 - width - index
+	}
+	return wordOffset, uint(index * 8)
+}
+
+func (b *Buffer) getBitsVolatile(offset uintptr, width uintptr) uint32 {
+	wordOffset, shift := b.word(offset, width)
+	return uint32(b.GetInt32Volatile(wordOffset)) >> shift
+}
+
+func (b *Buffer) putBitsVolatile(offset uintptr, width uintptr, v uint32) {
+	wordOffset, shift := b.word(offset, width)
+	mask := uint32(1)<<(width*8) - 1
+	for {
+		old := b.GetInt32Volatile(wordOffset)
+		new := int32(uint32(old)&^(mask<<shift) | v<<shift)
+		if b.CompareAndSwapInt32(wordOffset, old, new) {
+			return
+		}
+	}
 }
 
 // GetInt32 returns
 func (b *Buffer) GetInt32(offset uintptr) int32 {
-	return *(*int32)(unsafe.Pointer(b.addr + offset))
+	return *(*int32)(b.at(offset))
 }
 
 // GetInt32Volatile returns
 func (b *Buffer) GetInt32Volatile(offset uintptr) int32 {
-	return atomic.LoadInt32((*int32)(unsafe.Pointer(b.addr + offset)))
+	return atomic.LoadInt32((*int32)(b.at(offset)))
 }
 
 // PutInt32 sets
 func (b *Buffer) PutInt32(offset uintptr, v int32) {
-	*(*int32)(unsafe.Pointer(b.addr + offset)) = v
+	*(*int32)(b.at(offset)) = v
 }
 
 // PutInt32Volatile sets
 func (b *Buffer) PutInt32Volatile(offset uintptr, v int32) {
-	*(*int32)(unsafe.Pointer(b.addr + offset)) = v
+	atomic.StoreInt32((*int32)(b.at(offset)), v)
+}
+
+// CompareAndSwapInt32 sets
+func (b *Buffer) CompareAndSwapInt32(offset uintptr, old, new int32) bool {
+	return atomic.CompareAndSwapInt32((*int32)(b.at(offset)), old, new)
 }
 
 // GetInt64 returns
 func (b *Buffer) GetInt64(offset uintptr) int64 {
-	return *(*int64)(unsafe.Pointer(b.addr + offset))
+	return *(*int64)(b.at(offset))
 }
 
 // GetInt64Volatile returns
 func (b *Buffer) GetInt64Volatile(offset uintptr) int64 {
-	return atomic.LoadInt64((*int64)(unsafe.Pointer(b.addr + offset)))
+	return atomic.LoadInt64((*int64)(b.at(offset)))
 }
 
 // PutInt64 sets
 func (b *Buffer) PutInt64(offset uintptr, v int64) {
-	*(*int64)(unsafe.Pointer(b.addr + offset)) = v
+	*(*int64)(b.at(offset)) = v
 }
 
 // PutInt64Volatile sets
 func (b *Buffer) PutInt64Volatile(offset uintptr, v int64) {
-	atomic.StoreInt64((*int64)(unsafe.Pointer(b.addr+offset)), v)
+	atomic.StoreInt64((*int64)(b.at(offset)), v)
 }
 
 // AddInt64 sets
 func (b *Buffer) AddInt64(offset uintptr, delta int64) int64 {
-	return atomic.AddInt64((*int64)(unsafe.Pointer(b.addr+offset)), delta)
+	return atomic.AddInt64((*int64)(b.at(offset)), delta)
 }
 
 // SwapInt64 sets
 func (b *Buffer) SwapInt64(offset uintptr, new int64) (old int64) {
-	return atomic.SwapInt64((*int64)(unsafe.Pointer(b.addr+offset)), old)
+	return atomic.SwapInt64((*int64)(b.at(offset)), old)
 }
 
 // CompareAndSwapInt64 sets
 func (b *Buffer) CompareAndSwapInt64(offset uintptr, old, new int64) bool {
-	return atomic.CompareAndSwapInt64((*int64)(unsafe.Pointer(b.addr+offset)), old, new)
+	return atomic.CompareAndSwapInt64((*int64)(b.at(offset)), old, new)
+}
+
+// OrInt64 atomically sets bits of the mask and returns the old value.
+func (b *Buffer) OrInt64(offset uintptr, mask int64) (old int64) {
+	for {
+		old = b.GetInt64Volatile(offset)
+		if old|mask == old || b.CompareAndSwapInt64(offset, old, old|mask) {
+			return old
+		}
+	}
+}
+
+// AndInt64 atomically clears bits which are not in the mask and returns the old value.
+func (b *Buffer) AndInt64(offset uintptr, mask int64) (old int64) {
+	for {
+		old = b.GetInt64Volatile(offset)
+		if old&mask == old || b.CompareAndSwapInt64(offset, old, old&mask) {
+			return old
+		}
+	}
+}
+
+// GetFloat32 returns
+func (b *Buffer) GetFloat32(offset uintptr) float32 {
+	return math.Float32frombits(uint32(b.GetInt32(offset)))
+}
+
+// GetFloat32Volatile returns
+func (b *Buffer) GetFloat32Volatile(offset uintptr) float32 {
+	return math.Float32frombits(uint32(b.GetInt32Volatile(offset)))
+}
+
+// PutFloat32 sets
+func (b *Buffer) PutFloat32(offset uintptr, v float32) {
+	b.PutInt32(offset, int32(math.Float32bits(v)))
+}
+
+// PutFloat32Volatile sets
+func (b *Buffer) PutFloat32Volatile(offset uintptr, v float32) {
+	b.PutInt32Volatile(offset, int32(math.Float32bits(v)))
+}
+
+// AddFloat32 atomically adds delta to the float32 value and returns the new value.
+func (b *Buffer) AddFloat32(offset uintptr, delta float32) float32 {
+	for {
+		old := b.GetInt32Volatile(offset)
+		new := math.Float32frombits(uint32(old)) + delta
+		if b.CompareAndSwapInt32(offset, old, int32(math.Float32bits(new))) {
+			return new
+		}
+	}
+}
+
+// GetFloat64 returns
+func (b *Buffer) GetFloat64(offset uintptr) float64 {
+	return math.Float64frombits(uint64(b.GetInt64(offset)))
+}
+
+// GetFloat64Volatile returns
+func (b *Buffer) GetFloat64Volatile(offset uintptr) float64 {
+	return math.Float64frombits(uint64(b.GetInt64Volatile(offset)))
+}
+
+// PutFloat64 sets
+func (b *Buffer) PutFloat64(offset uintptr, v float64) {
+	b.PutInt64(offset, int64(math.Float64bits(v)))
+}
+
+// PutFloat64Volatile sets
+func (b *Buffer) PutFloat64Volatile(offset uintptr, v float64) {
+	b.PutInt64Volatile(offset, int64(math.Float64bits(v)))
+}
+
+// AddFloat64 atomically adds delta to the float64 value and returns the new value.
+func (b *Buffer) AddFloat64(offset uintptr, delta float64) float64 {
+	for {
+		old := b.GetInt64Volatile(offset)
+		new := math.Float64frombits(uint64(old)) + delta
+		if b.CompareAndSwapInt64(offset, old, int64(math.Float64bits(new))) {
+			return new
+		}
+	}
 }
 
 // PutString puts
@@ -105,10 +303,10 @@ func (b *Buffer) PutBytes(offset uintptr, bs []byte) {
 // PutSomeBytes sets
 func (b *Buffer) PutSomeBytes(offset uintptr, bs []byte, start, len int) {
 	var s = struct {
-		addr uintptr
+		addr unsafe.Pointer
 		len  int
 		cap  int
-	}{b.addr + offset, len, len}
+	}{b.at(offset), len, len}
 
 	dest := *(*[]byte)(unsafe.Pointer(&s))
 
@@ -118,10 +316,10 @@ func (b *Buffer) PutSomeBytes(offset uintptr, bs []byte, start, len int) {
 // GetBytes gets
 func (b *Buffer) GetBytes(offset uintptr, length int) (bs []byte) {
 	var s = struct {
-		addr uintptr
+		addr unsafe.Pointer
 		len  int
 		cap  int
-	}{b.addr + offset, length, length}
+	}{b.at(offset), length, length}
 
 	src := *(*[]byte)(unsafe.Pointer(&s))
 
@@ -136,3 +334,71 @@ func (b *Buffer) GetBytes(offset uintptr, length int) (bs []byte) {
 func (b *Buffer) GetString(offset uintptr, length int) string {
 	return string(b.GetBytes(offset, length))
 }
+
+// Reader returns a reader over the region of the buffer starting at the offset. Reads
+// past the end of the region return io.EOF. Panics if the region is out of the buffer.
+func (b *Buffer) Reader(offset uintptr, length int) io.Reader {
+	b.checkRegion(offset, length)
+	return &regionReader{region{buffer: b, offset: offset, length: length}}
+}
+
+// Writer returns a writer over the region of the buffer starting at the offset. Writes
+// past the end of the region are truncated and return io.ErrShortWrite. Panics if
+// the region is out of the buffer.
+func (b *Buffer) Writer(offset uintptr, length int) io.Writer {
+	b.checkRegion(offset, length)
+	return &regionWriter{region{buffer: b, offset: offset, length: length}}
+}
+
+func (b *Buffer) checkRegion(offset uintptr, length int) {
+	if length < 0 || offset > uintptr(b.capacity) || uintptr(length) > uintptr(b.capacity)-offset {
+		panic(fmt.Sprintf("region [%d:%d] out of buffer of capacity %d", offset, offset+uintptr(length), b.capacity))
+	}
+}
+
+// region is a cursor over a part of a buffer.
+type region struct {
+	buffer   *Buffer
+	offset   uintptr
+	length   int
+	position int
+}
+
+// next returns the size of the chunk of at most n bytes available at the cursor.
+func (r *region) next(n int) int {
+	if remaining := r.length - r.position; n > remaining {
+		return remaining
+	}
+	return n
+}
+
+type regionReader struct {
+	region
+}
+
+func (r *regionReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := r.next(len(p))
+	if n == 0 {
+		return 0, io.EOF
+	}
+	copy(p, r.buffer.GetBytes(r.offset+uintptr(r.position), n))
+	r.position += n
+	return n, nil
+}
+
+type regionWriter struct {
+	region
+}
+
+func (w *regionWriter) Write(p []byte) (int, error) {
+	n := w.next(len(p))
+	w.buffer.PutSomeBytes(w.offset+uintptr(w.position), p, 0, n)
+	w.position += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
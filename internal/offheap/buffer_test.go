@@ -4,25 +4,249 @@
 package offheap
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"runtime"
+	"sync"
 	"testing"
-	"unsafe"
 )
 
-func newBuffer() *Buffer {
-	bytes := make([]byte, 1000)
-	return NewBuffer(uintptr(unsafe.Pointer(&bytes)), cap(bytes))
-}
-
 func TestBuffer(t *testing.T) {
-	buffer := newBuffer()
+	bytes := make([]byte, 1000)
+	buffer := NewBufferFromSlice(bytes)
 
 	buffer.PutSomeBytes(2, []byte("atestb"), 2, 4)
 
 	s := string(buffer.GetString(2, 4))
 
-	sexp := "est"
+	sexp := "estb"
 
 	if s != sexp {
 		t.Fatalf("Bytes not matched. Expected: %s, got %s", sexp, s)
 	}
+	if string(bytes[2:6]) != sexp {
+		t.Fatalf("Bytes of the slice not matched. Expected: %s, got %s", sexp, bytes[2:6])
+	}
+}
+
+func TestRegionReaderWriter(t *testing.T) {
+	type record struct {
+		ID    int64
+		Value float64
+		Flags [4]uint8
+	}
+
+	buffer := NewBufferFromSlice(make([]byte, 64))
+
+	expected := record{ID: 42, Value: 3.5, Flags: [4]uint8{1, 2, 3, 4}}
+	size := binary.Size(expected)
+
+	if err := binary.Write(buffer.Writer(8, size), binary.LittleEndian, expected); err != nil {
+		t.Fatal(err)
+	}
+	if v := buffer.GetInt64(8); v != 42 {
+		t.Fatalf("Got %d, expected %d", v, 42)
+	}
+
+	var actual record
+	if err := binary.Read(buffer.Reader(8, size), binary.LittleEndian, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual != expected {
+		t.Fatalf("Got %v, expected %v", actual, expected)
+	}
+
+	if err := binary.Write(buffer.Writer(8, size-1), binary.LittleEndian, expected); err != io.ErrShortWrite {
+		t.Fatalf("Got %v, expected %v", err, io.ErrShortWrite)
+	}
+	if err := binary.Read(buffer.Reader(8, size-1), binary.LittleEndian, &actual); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Got %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+
+	var all bytes.Buffer
+	if _, err := io.Copy(&all, buffer.Reader(60, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if all.Len() != 4 {
+		t.Fatalf("Got %d bytes, expected %d", all.Len(), 4)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Region out of the buffer accepted")
+		}
+	}()
+	buffer.Reader(60, 5)
+}
+
+func TestBufferFromSlice(t *testing.T) {
+	buffer := NewBufferFromSlice(make([]byte, 64)).Slice(8, 56)
+
+	// Only the slice of the buffer is reachable now
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	garbage := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		garbage = append(garbage, make([]byte, 64))
+	}
+
+	buffer.PutInt64Volatile(0, 42)
+	buffer.PutString(8, "value")
+	buffer.AddInt64(0, 1)
+
+	if v := buffer.GetInt64Volatile(0); v != 43 {
+		t.Fatalf("Got %d, expected %d", v, 43)
+	}
+	if s := buffer.GetString(8, 5); s != "value" {
+		t.Fatalf("Got %s, expected %s", s, "value")
+	}
+	if len(garbage) != 100 {
+		t.Fatal("Unexpected garbage")
+	}
+
+	if empty := NewBufferFromSlice(nil); empty.Capacity() != 0 {
+		t.Fatalf("Got capacity %d, expected 0", empty.Capacity())
+	}
+}
+
+func TestSmallInts(t *testing.T) {
+	buffer := NewBufferFromSlice(make([]byte, 8))
+
+	for _, v := range []int8{0, 1, -1, math.MaxInt8, math.MinInt8} {
+		for offset := uintptr(0); offset < 8; offset++ {
+			buffer.PutInt64(0, -1)
+			buffer.PutInt8(offset, v)
+			if got := buffer.GetInt8(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			buffer.PutInt64(0, 0x0102030405060708)
+			buffer.PutInt8Volatile(offset, v)
+			if got := buffer.GetInt8Volatile(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			if got := buffer.GetInt8(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			buffer.PutInt8(offset, 0)
+			expected := NewBufferFromSlice(make([]byte, 8))
+			expected.PutInt64(0, 0x0102030405060708)
+			expected.PutInt8(offset, 0)
+			if buffer.GetInt64(0) != expected.GetInt64(0) {
+				t.Fatalf("Neighbours of %d were modified: %x", offset, buffer.GetInt64(0))
+			}
+		}
+	}
+
+	for _, v := range []int16{0, 1, -1, math.MaxInt16, math.MinInt16} {
+		for offset := uintptr(0); offset < 8; offset += 2 {
+			buffer.PutInt64(0, -1)
+			buffer.PutInt16(offset, v)
+			if got := buffer.GetInt16(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			buffer.PutInt64(0, 0x0102030405060708)
+			buffer.PutInt16Volatile(offset, v)
+			if got := buffer.GetInt16Volatile(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			if got := buffer.GetInt16(offset); got != v {
+				t.Fatalf("Got %d at %d, expected %d", got, offset, v)
+			}
+			buffer.PutInt16(offset, 0)
+			expected := NewBufferFromSlice(make([]byte, 8))
+			expected.PutInt64(0, 0x0102030405060708)
+			expected.PutInt16(offset, 0)
+			if buffer.GetInt64(0) != expected.GetInt64(0) {
+				t.Fatalf("Neighbours of %d were modified: %x", offset, buffer.GetInt64(0))
+			}
+		}
+	}
+}
+
+func TestOrAndInt64(t *testing.T) {
+	buffer := NewBufferFromSlice(make([]byte, 8)) // 8 bytes are allocated aligned on 8
+
+	var wg sync.WaitGroup
+	wg.Add(64)
+	for i := 0; i < 64; i++ {
+		go func(bit uint) {
+			buffer.OrInt64(0, int64(1)<<bit)
+			wg.Done()
+		}(uint(i))
+	}
+	wg.Wait()
+
+	if v := buffer.GetInt64Volatile(0); v != -1 {
+		t.Fatalf("All bits must be set, got: %x", v)
+	}
+
+	wg.Add(32)
+	for i := 0; i < 32; i++ {
+		go func(bit uint) {
+			buffer.AndInt64(0, ^(int64(1) << bit))
+			wg.Done()
+		}(uint(i * 2))
+	}
+	wg.Wait()
+
+	expected := int64(-6148914691236517206) // 0xAAAAAAAAAAAAAAAA
+	if v := buffer.GetInt64Volatile(0); v != expected {
+		t.Fatalf("Even bits must be cleared, got: %x, expected: %x", v, expected)
+	}
+
+	if old := buffer.OrInt64(0, 1); old != expected {
+		t.Fatalf("Old value %x, expected %x", old, expected)
+	}
+}
+
+func TestFloat32(t *testing.T) {
+	buffer := NewBufferFromSlice(make([]byte, 8)) // 8 bytes are allocated aligned on 8
+
+	values := []float32{
+		0,
+		float32(math.Copysign(0, -1)),
+		1.5,
+		-2.25,
+		math.MaxFloat32,
+		math.SmallestNonzeroFloat32, // subnormal
+		float32(math.Inf(1)),
+		float32(math.Inf(-1)),
+		float32(math.NaN()),
+	}
+
+	// Two gauges per 8 bytes
+	for _, v := range values {
+		buffer.PutFloat32(0, v)
+		buffer.PutFloat32Volatile(4, -v)
+
+		if got := buffer.GetFloat32(0); math.Float32bits(got) != math.Float32bits(v) {
+			t.Fatalf("Got %g, expected %g", got, v)
+		}
+		if got := buffer.GetFloat32Volatile(4); math.Float32bits(got) != math.Float32bits(-v) {
+			t.Fatalf("Got %g, expected %g", got, -v)
+		}
+	}
+
+	buffer.PutFloat32(0, 0)
+	buffer.PutFloat32(4, 7)
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			buffer.AddFloat32(0, 0.5)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if v := buffer.GetFloat32Volatile(0); v != 5 {
+		t.Fatalf("Got %g, expected %g", v, 5.0)
+	}
+	if v := buffer.GetFloat32Volatile(4); v != 7 {
+		t.Fatalf("The neighbour gauge must not be changed, got %g", v)
+	}
 }
@@ -0,0 +1,60 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import "sync"
+
+// ReaderPool hands out handles of a reader, which share its mapping, so goroutines
+// serving concurrent requests don't share the same Reader. The handles must not be used
+// after the reader is closed.
+type ReaderPool struct {
+	reader *Reader
+	pool   sync.Pool
+}
+
+// NewReaderPool creates a pool of handles of the reader.
+func NewReaderPool(r *Reader) *ReaderPool {
+	p := &ReaderPool{
+		reader: r,
+	}
+	p.pool.New = func() interface{} {
+		return p.newHandle()
+	}
+	return p
+}
+
+// Reader returns the reader the handles are taken from.
+func (p *ReaderPool) Reader() *Reader {
+	return p.reader
+}
+
+// Get returns a handle of the reader. It should be returned to the pool with Put.
+func (p *ReaderPool) Get() *Reader {
+	return p.pool.Get().(*Reader)
+}
+
+// Put returns the handle taken with Get to the pool. Closed handles aren't reused.
+func (p *ReaderPool) Put(h *Reader) {
+	h.observeLock.Lock()
+	closed := h.closed
+	h.observeLock.Unlock()
+	if closed {
+		return
+	}
+	p.pool.Put(h)
+}
+
+// newHandle creates a reader sharing the mapping of the pool's reader. Closing of
+// the handle doesn't unmap the mapping.
+func (p *ReaderPool) newHandle() *Reader {
+	r := p.reader
+	return &Reader{
+		buffer:   r.buffer,
+		decoder:  r.decoder,
+		writable: r.writable,
+		data:     r.data,
+		filename: r.filename,
+		fileInfo: r.fileInfo,
+	}
+}
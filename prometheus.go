@@ -0,0 +1,135 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promSample is a value of a counter to be exposed.
+type promSample struct {
+	id    int64
+	label string
+	value string
+}
+
+// promMetric groups the counters exposed with the same metric name.
+type promMetric struct {
+	counterType CounterType
+	samples     []promSample
+}
+
+// WritePrometheus writes the counters in the Prometheus text exposition format. The name of
+// a counter's metric is its label prefixed with the namespace, if any, with the characters
+// illegal in metric names replaced with '_'. Since labels aren't unique, each sample has
+// the counter's id and original label as its labels. The metrics are typed as gauges, since
+// the counters can be set to any value. Min/max counters also have the metrics with the "_min",
+// "_max" and "_count" suffixes; the min and the max are written once a value is recorded.
+func (r *Reader) WritePrometheus(w io.Writer, namespace string) error {
+	metrics := make(map[string]*promMetric)
+	// add adds the sample to the metric with the name, or with the name suffixed with
+	// the counter's type if the metric has samples of another type, and returns the name.
+	add := func(name string, counterType CounterType, s promSample) string {
+		m, has := metrics[name]
+		if !has {
+			m = &promMetric{counterType: counterType}
+			metrics[name] = m
+		} else if m.counterType != counterType {
+			name = promMetricName(name, promTypeName(counterType))
+			if m, has = metrics[name]; !has {
+				m = &promMetric{counterType: counterType}
+				metrics[name] = m
+			}
+		}
+		m.samples = append(m.samples, s)
+		return name
+	}
+	r.decoder.ForEachTypedCounter(func(id int64, counterType CounterType, value int64, label string) bool {
+		name := add(promMetricName(namespace, label), counterType,
+			promSample{id: id, label: label, value: FormatValue(counterType, value)})
+		if counterType != CounterTypeMinMax {
+			return true
+		}
+		_, min, max, count, err := r.decoder.GetCounterMinMax(id)
+		if err != nil { // the counter has been closed
+			return true
+		}
+		if count > 0 {
+			add(name+"_min", counterType, promSample{id: id, label: label, value: strconv.FormatInt(min, 10)})
+			add(name+"_max", counterType, promSample{id: id, label: label, value: strconv.FormatInt(max, 10)})
+		}
+		add(name+"_count", counterType, promSample{id: id, label: label, value: strconv.FormatInt(count, 10)})
+		return true
+	})
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		m := metrics[name]
+		bw.WriteString("# HELP " + name + " mc4go " + promTypeName(m.counterType) + " counter " + promEscapeHelp(m.samples[0].label) + "\n")
+		bw.WriteString("# TYPE " + name + " gauge\n")
+		for _, s := range m.samples {
+			bw.WriteString(name + `{id="` + strconv.FormatInt(s.id, 10) + `",label="` + promEscapeLabelValue(s.label) + `"} ` + s.value + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// promMetricName joins the namespace and the label with '_' and replaces the characters
+// illegal in a metric name with '_'.
+func promMetricName(namespace, label string) string {
+	name := label
+	if namespace != "" {
+		name = namespace + "_" + label
+	}
+	if name == "" {
+		return "_"
+	}
+	var sb strings.Builder
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			sb.WriteRune(c)
+			continue
+		}
+		if i == 0 && c >= '0' && c <= '9' {
+			sb.WriteByte('_')
+			sb.WriteRune(c)
+			continue
+		}
+		sb.WriteByte('_')
+	}
+	return sb.String()
+}
+
+func promTypeName(counterType CounterType) string {
+	switch counterType {
+	case CounterTypeFloat64:
+		return "float64"
+	case CounterTypeMinMax:
+		return "minmax"
+	default:
+		return "int64"
+	}
+}
+
+var promHelpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func promEscapeHelp(s string) string {
+	return promHelpReplacer.Replace(s)
+}
+
+var promLabelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func promEscapeLabelValue(s string) string {
+	return promLabelValueReplacer.Replace(s)
+}
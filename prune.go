@@ -0,0 +1,49 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// IsWriterAlive returns true if the process, which wrote the counters, is still running.
+func (r *Reader) IsWriterAlive() (alive bool, err error) {
+	return isProcessAlive(r.Pid())
+}
+
+// PruneStaleFiles removes the counters' files of the directory specified, which writers aren't running anymore.
+// Files, which can't be read as counters' files or the writers' state of which can't be checked, are kept.
+func PruneStaleFiles(dir string) (removed []string, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		filename := path.Join(dir, info.Name())
+		if !isStale(filename) {
+			continue
+		}
+		if err = os.Remove(filename); err != nil {
+			return removed, err
+		}
+		removed = append(removed, filename)
+	}
+	return removed, nil
+}
+
+func isStale(filename string) bool {
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	alive, err := r.IsWriterAlive()
+	return err == nil && !alive
+}
@@ -4,25 +4,48 @@
 package mc4go
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"os"
 	"os/user"
 	"path"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/anatolygudkov/mc4go/internal/layout"
 	"github.com/anatolygudkov/mc4go/internal/mmap"
 	"github.com/anatolygudkov/mc4go/internal/offheap"
 )
 
-// GetMCountersDirectoryPath returns
+// GetMCountersDirectoryPath returns the directory of the counters' files. It's specified with
+// the mcounters.dir environment variable, otherwise it's mcounters-<username> in /dev/shm on Linux
+// or in the temporary directory. The mcounters.prefix environment variable replaces the "mcounters" prefix.
 func GetMCountersDirectoryPath() (p string) {
 	p = os.Getenv("mcounters.dir")
 	if p != "" {
 		return
 	}
 
+	username := ""
+	u, err := user.Current()
+	if err == nil {
+		username = u.Username
+	}
+
+	return GetMCountersDirectoryPathFor(os.Getenv("mcounters.prefix"), username)
+}
+
+// GetMCountersDirectoryPathFor returns the path <prefix>-<username> in /dev/shm on Linux
+// or in the temporary directory. The prefix defaults to "mcounters", the username to "default".
+func GetMCountersDirectoryPathFor(prefix, username string) (p string) {
 	baseDir := ""
 	if runtime.GOOS == `linux` {
 		shm := "/dev/shm"
@@ -35,49 +58,281 @@ func GetMCountersDirectoryPath() (p string) {
 		baseDir = os.TempDir()
 	}
 
-	username := "default"
-	u, err := user.Current()
-	if err == nil {
-		if u.Username != "" {
-			username = u.Username
-		}
+	if prefix == "" {
+		prefix = "mcounters"
+	}
+	if username == "" {
+		username = "default"
 	}
 
-	p = path.Join(baseDir, "mcounters-"+username)
+	p = path.Join(baseDir, prefix+"-"+username)
 	return
 }
 
+// CounterType defines how a counter's value is encoded in its value slot.
+type CounterType = layout.CounterType
+
+const (
+	// CounterTypeInt64 is a counter with int64 value.
+	CounterTypeInt64 = layout.CounterTypeInt64
+	// CounterTypeFloat64 is a counter with float64 value.
+	CounterTypeFloat64 = layout.CounterTypeFloat64
+	// CounterTypeMinMax is a counter with int64 value tracking the min and the max of its values.
+	CounterTypeMinMax = layout.CounterTypeMinMax
+)
+
+// MinMaxValueStride is the min WriterOptions.ValueStride allowing Writer.AddMinMaxCounter.
+const MinMaxValueStride = layout.MinMaxValueStride
+
+// FeatureFlags tells which extensions of the format a counters' file uses.
+type FeatureFlags uint32
+
+const (
+	// FeatureBigEndian is set if the numbers are stored in the big endian byte order.
+	FeatureBigEndian = FeatureFlags(layout.FeatureBigEndian)
+	// FeatureValueStride is set if the value stride isn't the default one.
+	FeatureValueStride = FeatureFlags(layout.FeatureValueStride)
+	// FeatureFloatCounters is set once a float counter is added.
+	FeatureFloatCounters = FeatureFlags(layout.FeatureFloatCounters)
+	// FeatureExplicitIDs is set if the writer reserved IDs with WriterOptions.ExplicitIDs.
+	FeatureExplicitIDs = FeatureFlags(layout.FeatureExplicitIDs)
+	// FeatureMinMaxCounters is set once a min/max counter is added.
+	FeatureMinMaxCounters = FeatureFlags(layout.FeatureMinMaxCounters)
+	// FeatureReservedSlots is set once the writer reserved a slot with Writer.Reserve.
+	FeatureReservedSlots = FeatureFlags(layout.FeatureReservedSlots)
+)
+
+// Has returns true if all the features specified are set.
+func (f FeatureFlags) Has(features FeatureFlags) bool {
+	return f&features == features
+}
+
+// FileInfo describes the layout of a counters' file.
+type FileInfo struct {
+	Version        int32
+	StaticsLength  int
+	MetadataLength int
+	ValuesLength   int
+	MaxCounters    int
+	ValueStride    int
+}
+
 // Reader reads
 type Reader struct {
-	buffer  *offheap.Buffer
-	decoder *layout.Decoder
+	buffer   *offheap.Buffer
+	decoder  *layout.Decoder
+	mapped   bool // true if the buffer must be unmapped on close
+	writable bool // true if the buffer is mapped for writing
+	closed   bool
+	data     []byte // keeps bytes of a reader created with NewReaderForBytes reachable
+	filename string
+	fileInfo os.FileInfo // info of the file mapped, if created for a file
+
+	observeLock  sync.Mutex    // guards closed and observeDone
+	observeDone  chan struct{} // closed on Close to stop the observers
+	observeReads sync.RWMutex  // read locked by the observers polling the buffer, awaited before unmapping
 }
 
-// NewReader creates
+// NewReader creates a reader over the buffer. The buffer must be aligned on 8 bytes,
+// since 32-bit platforms don't support atomic access to unaligned 64-bit values.
 func NewReader(buf *offheap.Buffer) (r *Reader, err error) {
+	if buf.Address()%layout.Alignment != 0 {
+		return nil, fmt.Errorf("counters buffer isn't aligned on %d bytes: 0x%x", layout.Alignment, buf.Address())
+	}
+
+	if buf.Capacity() < layout.HeaderLength() {
+		return nil, fmt.Errorf("too few bytes for the counters: %d", buf.Capacity())
+	}
+
 	decoder := layout.NewDecoder(buf)
+	if err := checkDecoder(decoder); err != nil {
+		return nil, err
+	}
 
-	version := decoder.Version()
-	if version == 0 {
-		return nil, errors.New("counters haven't been initialized yet")
+	r = &Reader{
+		buffer:  buf,
+		decoder: decoder,
+		mapped:  true,
+	}
+	if used := r.UsedSize(); used > buf.Capacity() {
+		return nil, fmt.Errorf("too few bytes for the counters: %d, expected %d", buf.Capacity(), used)
+	}
+	return r, nil
+}
+
+// NewReaderWithBuffers creates a reader over the sections of the counters placed in separate buffers,
+// for example, mapped by the caller. The buffers must be aligned on 8 bytes. They aren't unmapped
+// when the reader is closed.
+func NewReaderWithBuffers(header, statics, metadata, values *offheap.Buffer) (r *Reader, err error) {
+	if header.Capacity() < layout.HeaderLength() {
+		return nil, fmt.Errorf("header buffer is too small %d, expected %d", header.Capacity(), layout.HeaderLength())
 	}
-	if version != layout.CountersVersion {
-		return nil, fmt.Errorf("unexpected version of the counters file: %d", version)
+
+	decoder := layout.NewDecoderWithBuffers(header, statics, metadata, values)
+	if err := checkDecoder(decoder); err != nil {
+		return nil, err
 	}
 
 	return &Reader{
-		buffer:  buf,
 		decoder: decoder,
 	}, nil
 }
 
+// checkDecoder returns an error if the sections of the decoder can't be read.
+func checkDecoder(decoder *layout.Decoder) error {
+	l := decoder.Layout
+	if l.Statics.Capacity() < 0 || l.CountersMetadata.Capacity() < 0 || l.CountersValues.Capacity() < 0 {
+		return fmt.Errorf("negative length of a section: statics=%d, metadata=%d, values=%d",
+			l.Statics.Capacity(), l.CountersMetadata.Capacity(), l.CountersValues.Capacity())
+	}
+	if err := l.CheckAlignment(); err != nil {
+		return err
+	}
+
+	version := decoder.Version()
+	if version == 0 {
+		return errors.New("counters haven't been initialized yet")
+	}
+	if err := checkVersion(version, layout.CountersVersion, layout.MaxCountersVersion); err != nil {
+		return err
+	}
+	if unknown := decoder.Features() &^ layout.KnownFeatures; unknown != 0 {
+		return fmt.Errorf("counters file uses unknown features %b; upgrade the tool", unknown)
+	}
+	return nil
+}
+
+// checkVersion returns an error telling which side should be upgraded if the version of a file isn't supported.
+func checkVersion(version, min, max int32) error {
+	if version > max {
+		return fmt.Errorf("counters file is newer than this reader supports (file=%d, max=%d); upgrade the tool", version, max)
+	}
+	if version < min {
+		return fmt.Errorf("counters file is older than this reader supports (file=%d, min=%d); upgrade the writer or use an older tool", version, min)
+	}
+	return nil
+}
+
+// NewReaderForBytes creates a reader over a copy of a counters' file, for example, returned by CopyBytes.
+// The bytes must not be modified while the reader is in use and must be aligned on 8 bytes,
+// which is true for slices allocated with make.
+func NewReaderForBytes(b []byte) (r *Reader, err error) {
+	if len(b) < layout.HeaderLength() {
+		return nil, fmt.Errorf("too few bytes for the counters: %d", len(b))
+	}
+
+	r, err = NewReader(offheap.NewBufferFromSlice(b))
+	if err != nil {
+		return nil, err
+	}
+	r.mapped = false
+	r.data = b
+
+	return r, nil
+}
+
 // NewReaderForFile creates
 func NewReaderForFile(filename string) (r *Reader, err error) {
-	buf, err := mmap.MapExistingFileReadOnly(filename)
+	buf, fi, err := mmap.MapExistingFileReadOnlyWithInfo(filename)
 	if err != nil {
 		return nil, err
 	}
-	return NewReader(buf)
+	r, err = NewReader(buf)
+	if err != nil {
+		mmap.Unmap(buf)
+		return nil, err
+	}
+	r.filename = filename
+	r.fileInfo = fi
+	return r, nil
+}
+
+// ReaderOptions tunes a reader of a counters' file.
+type ReaderOptions struct {
+	// Prefetch makes the reader fault in all the pages of the file when it's opened, so the first
+	// iteration over the counters doesn't wait for the page faults. It's useful for the readers
+	// scanning the whole file right after opening, for example, batch collectors.
+	Prefetch bool
+}
+
+// NewReaderForFileWithOptions creates a reader as NewReaderForFile does with the options specified.
+func NewReaderForFileWithOptions(filename string, options ReaderOptions) (r *Reader, err error) {
+	r, err = NewReaderForFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if options.Prefetch {
+		mmap.Prefetch(r.buffer)
+	}
+	return r, nil
+}
+
+// Delays between attempts of NewReaderForFileAwait to open the file.
+const (
+	awaitMinBackoff = 10 * time.Millisecond
+	awaitMaxBackoff = time.Second
+)
+
+// NewReaderForFileAwait creates a reader as NewReaderForFile does, but if the file doesn't exist
+// or isn't initialized yet, it retries with a growing delay until the timeout is exceeded.
+func NewReaderForFileAwait(filename string, timeout time.Duration) (r *Reader, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	r, err = awaitReaderForFile(ctx, filename)
+	if r == nil {
+		return nil, fmt.Errorf("counters file %s isn't ready in %v: %v", filename, timeout, err)
+	}
+	return r, nil
+}
+
+// NewReaderForFileContext creates a reader as NewReaderForFileAwait does, but retries
+// until the context is done. The context's error is returned in that case.
+func NewReaderForFileContext(ctx context.Context, filename string) (r *Reader, err error) {
+	r, _ = awaitReaderForFile(ctx, filename)
+	if r == nil {
+		return nil, ctx.Err()
+	}
+	return r, nil
+}
+
+// awaitReaderForFile tries to create a reader until the context is done. It returns
+// the error of the last attempt if no reader is created.
+func awaitReaderForFile(ctx context.Context, filename string) (r *Reader, err error) {
+	backoff := awaitMinBackoff
+	for {
+		r, err = NewReaderForFile(filename)
+		if err == nil {
+			return r, nil
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > awaitMaxBackoff {
+			backoff = awaitMaxBackoff
+		}
+	}
+}
+
+// NewReaderForFileWritable creates a reader which maps the file for writing too,
+// so values of the counters can be changed with SetCounterValue.
+func NewReaderForFileWritable(filename string) (r *Reader, err error) {
+	buf, fi, err := mmap.MapExistingFileWithInfo(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err = NewReader(buf)
+	if err != nil {
+		mmap.Unmap(buf)
+		return nil, err
+	}
+	r.writable = true
+	r.filename = filename
+	r.fileInfo = fi
+	return r, nil
 }
 
 // NewReaderForName creates
@@ -85,6 +340,41 @@ func NewReaderForName(name string) (r *Reader, err error) {
 	return NewReaderForFile(path.Join(GetMCountersDirectoryPath(), name))
 }
 
+// NewReaderForSharedMemory creates a reader attached to the named shared memory segment
+// created by NewWriterForSharedMemory.
+func NewReaderForSharedMemory(name string) (r *Reader, err error) {
+	buf, err := mmap.MapExistingSharedMemoryReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err = NewReader(buf)
+	if err != nil {
+		mmap.Unmap(buf)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Filename returns the path to the counters' file.
+// It's empty if the reader isn't created for a file.
+func (r *Reader) Filename() string {
+	return r.filename
+}
+
+// DetectRecreation returns true if the file the reader is created for was replaced with another one,
+// for example, removed and created again by a new writer. The reader keeps serving the content
+// of the original file in that case, so it should be closed and created again.
+func (r *Reader) DetectRecreation() (recreated bool, err error) {
+	if r.fileInfo == nil {
+		return false, errors.New("the reader isn't created for a file")
+	}
+	fi, err := os.Stat(r.filename)
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(r.fileInfo, fi), nil
+}
+
 // Version returns
 func (r *Reader) Version() int32 {
 	return r.decoder.Version()
@@ -100,32 +390,496 @@ func (r *Reader) StartTime() int64 {
 	return r.decoder.StartTime()
 }
 
+// Epoch returns the epoch of the values incremented with Writer.BumpEpoch.
+func (r *Reader) Epoch() int64 {
+	return r.decoder.Epoch()
+}
+
+// Features returns the extensions of the format used by the counters' file.
+func (r *Reader) Features() FeatureFlags {
+	return FeatureFlags(r.decoder.Features())
+}
+
+// FileInfo returns lengths of the file's sections and max number of counters the file can contain.
+func (r *Reader) FileInfo() FileInfo {
+	l := r.decoder.Layout
+	return FileInfo{
+		Version:        r.decoder.Version(),
+		StaticsLength:  l.Statics.Capacity(),
+		MetadataLength: l.CountersMetadata.Capacity(),
+		ValuesLength:   l.CountersValues.Capacity(),
+		MaxCounters:    layout.MaxCounters(l.CountersMetadata.Capacity()),
+		ValueStride:    r.decoder.ValueStride(),
+	}
+}
+
 // ForEachStatic returns
 func (r *Reader) ForEachStatic(consumer func(label, value string) bool) {
 	r.decoder.ForEachStatic(consumer)
 }
 
+// ForEachStaticErr iterates over the statics until the consumer returns an error, which is returned then.
+func (r *Reader) ForEachStaticErr(consumer func(label, value string) error) (err error) {
+	r.decoder.ForEachStatic(func(label, value string) bool {
+		err = consumer(label, value)
+		return err == nil
+	})
+	return err
+}
+
+// StaticsInto appends label/value pairs of all statics to dst and returns the extended slice.
+func (r *Reader) StaticsInto(dst [][2]string) [][2]string {
+	return r.decoder.StaticsInto(dst)
+}
+
 // GetStaticValue returns
 func (r *Reader) GetStaticValue(label string) (v string, err error) {
 	return r.decoder.GetStaticValue(label)
 }
 
+// GetStaticInt returns the value of the static parsed as a decimal integer.
+func (r *Reader) GetStaticInt(label string) (v int64, err error) {
+	s, err := r.decoder.GetStaticValue(label)
+	if err != nil {
+		return 0, err
+	}
+	v, err = strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("static %s isn't an integer: %v", label, err)
+	}
+	return v, nil
+}
+
+// GetStaticBool returns the value of the static parsed with strconv.ParseBool,
+// so 1, t, true, 0, f, false and their upper case forms are accepted.
+func (r *Reader) GetStaticBool(label string) (v bool, err error) {
+	s, err := r.decoder.GetStaticValue(label)
+	if err != nil {
+		return false, err
+	}
+	v, err = strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("static %s isn't a boolean: %v", label, err)
+	}
+	return v, nil
+}
+
+// GetStaticValueFold returns the value of the static whose label equals to the label specified
+// ignoring case. If several labels match, the value of the first stored one is returned.
+func (r *Reader) GetStaticValueFold(label string) (v string, err error) {
+	found := false
+	r.decoder.ForEachStatic(func(l, value string) bool {
+		if strings.EqualFold(l, label) {
+			v = value
+			found = true
+			return false
+		}
+		return true
+	})
+	if !found {
+		return "", fmt.Errorf("label %s isn't found", label)
+	}
+	return v, nil
+}
+
 // ForEachCounter returns
 func (r *Reader) ForEachCounter(consumer func(id, value int64, label string) bool) {
 	r.decoder.ForEachCounter(consumer)
 }
 
+// ForEachTypedCounter iterates over the counters as ForEachCounter does, passing the types of the counters.
+func (r *Reader) ForEachTypedCounter(consumer func(id int64, counterType CounterType, value int64, label string) bool) {
+	r.decoder.ForEachTypedCounter(consumer)
+}
+
+// FormatValue returns the value of a counter of the type as a decimal number.
+func FormatValue(counterType CounterType, value int64) string {
+	if counterType == CounterTypeFloat64 {
+		return strconv.FormatFloat(math.Float64frombits(uint64(value)), 'g', -1, 64)
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// ForEachCounterLive iterates over the counters as ForEachCounter does, but scans all the slots
+// up to the capacity instead of stopping at the first never used one. Repeated calls pick up
+// the counters allocated by the writer meanwhile wherever their slots are.
+func (r *Reader) ForEachCounterLive(consumer func(id, value int64, label string) bool) {
+	r.decoder.ForEachTypedCounterLive(func(id int64, counterType CounterType, value int64, label string) bool {
+		return consumer(id, value, label)
+	})
+}
+
+// contextCheckInterval is how many counters ForEachCounterContext passes to the consumer between checks of the context.
+const contextCheckInterval = 64
+
+// ForEachCounterContext iterates over the counters as ForEachCounter does, but stops
+// and returns the context's error if the context is done.
+func (r *Reader) ForEachCounterContext(ctx context.Context, consumer func(id, value int64, label string) bool) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	n := 0
+	r.decoder.ForEachCounter(func(id, value int64, label string) bool {
+		n++
+		if n%contextCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		return consumer(id, value, label)
+	})
+	return err
+}
+
+// SumCountersMatching returns the sum of the values of the int64 counters with the labels matching
+// the regular expression and the number of such counters. Float counters are skipped.
+func (r *Reader) SumCountersMatching(re *regexp.Regexp) (sum int64, count int) {
+	r.decoder.ForEachTypedCounter(func(id int64, counterType CounterType, value int64, label string) bool {
+		if counterType == CounterTypeInt64 && re.MatchString(label) {
+			sum += value
+			count++
+		}
+		return true
+	})
+	return sum, count
+}
+
 // GetCounterValue returns
 func (r *Reader) GetCounterValue(counterID int64) (value int64, err error) {
 	return r.decoder.GetCounterValue(counterID)
 }
 
+// GetCounterValues returns the values of the counters with the ids specified and the ids
+// of the counters which aren't found or aren't allocated. Unlike calling GetCounterValue for each id,
+// it scans the metadata once.
+func (r *Reader) GetCounterValues(ids []int64) (values map[int64]int64, missing []int64) {
+	wanted := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
+	}
+
+	values = make(map[int64]int64, len(wanted))
+	r.decoder.GetCounterValues(wanted, values)
+
+	for _, id := range ids {
+		if _, found := values[id]; found {
+			continue
+		}
+		if _, reported := wanted[id]; reported {
+			missing = append(missing, id)
+			delete(wanted, id) // Report duplicated ids once
+		}
+	}
+	return values, missing
+}
+
+// maxCounterSnapshotAttempts limits rereading of the counters modified while they are snapshotted.
+const maxCounterSnapshotAttempts = 100
+
+// SnapshotCounters returns the values of the counters with the ids specified as of the same moment.
+// The values are read twice, and the reading is repeated until no counter is modified or
+// reallocated in between. It fails if a counter isn't found or the counters keep changing,
+// so a snapshot of counters updated continuously may fail and should be retried later.
+func (r *Reader) SnapshotCounters(ids []int64) (values map[int64]int64, err error) {
+	slots := make(map[int64]layout.CounterSlot, len(ids))
+	for _, id := range ids {
+		if _, has := slots[id]; has {
+			continue
+		}
+		if slots[id], err = r.decoder.FindCounter(id); err != nil {
+			return nil, err
+		}
+	}
+
+	values = make(map[int64]int64, len(slots))
+
+	for attempt := 0; attempt < maxCounterSnapshotAttempts; attempt++ {
+		if attempt > 0 {
+			runtime.Gosched() // Let the writer finish the modification
+		}
+
+		stable := true
+		for id, slot := range slots {
+			v, ok := r.decoder.GetCounterValueAt(slot)
+			if !ok {
+				if slots[id], err = r.decoder.FindCounter(id); err != nil {
+					return nil, err
+				}
+				stable = false
+				break
+			}
+			values[id] = v
+		}
+		if !stable {
+			continue
+		}
+
+		for id, slot := range slots {
+			if v, ok := r.decoder.GetCounterValueAt(slot); !ok || v != values[id] {
+				stable = false
+				break
+			}
+		}
+		if stable {
+			return values, nil
+		}
+	}
+	return nil, fmt.Errorf("the counters kept changing during %d attempts to snapshot them", maxCounterSnapshotAttempts)
+}
+
+// Observe starts a goroutine which polls the counter's value with the interval specified
+// and calls cb once the value is changed. The goroutine exits when the returned function
+// is called or the reader is closed. The returned function waits for the goroutine to exit,
+// so it must not be called from cb, while cb may close the reader. Polls of the counter
+// not found are skipped.
+func (r *Reader) Observe(id int64, interval time.Duration, cb func(old, new int64)) (stop func()) {
+	r.observeLock.Lock()
+	defer r.observeLock.Unlock()
+
+	if r.closed {
+		return func() {}
+	}
+	if r.observeDone == nil {
+		r.observeDone = make(chan struct{})
+	}
+
+	readerDone := r.observeDone
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		old, err := r.pollCounter(id, readerDone)
+		known := err == nil
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-readerDone:
+				return
+			case <-ticker.C:
+				v, err := r.pollCounter(id, readerDone)
+				if err == errObserveClosed {
+					return
+				}
+				if err != nil {
+					continue
+				}
+				if known && v != old {
+					cb(old, v)
+				}
+				old = v
+				known = true
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopped)
+		})
+		<-done
+	}
+}
+
+// errObserveClosed is returned by pollCounter once the reader is closed.
+var errObserveClosed = errors.New("the reader is closed")
+
+// pollCounter returns the counter's value unless the reader is closed. The buffer isn't
+// unmapped by Close until the value is read.
+func (r *Reader) pollCounter(id int64, readerDone <-chan struct{}) (value int64, err error) {
+	r.observeReads.RLock()
+	defer r.observeReads.RUnlock()
+	select {
+	case <-readerDone:
+		return 0, errObserveClosed
+	default:
+	}
+	return r.GetCounterValue(id)
+}
+
+// Fingerprint returns a hash of the statics and the labels and the values of the allocated counters.
+// It doesn't depend on the order of the statics and the counters, so equal fingerprints tell that
+// files are very likely identical snapshots. The value is consistent only if the file isn't modified meanwhile.
+func (r *Reader) Fingerprint() (fp uint64) {
+	h := fnv.New64a()
+	var value [8]byte
+	// Hashes of the items are summed, so they can be visited in any order
+	r.decoder.ForEachStatic(func(label, v string) bool {
+		h.Reset()
+		h.Write([]byte{'s'})
+		h.Write([]byte(label))
+		h.Write([]byte{0})
+		h.Write([]byte(v))
+		fp += h.Sum64()
+		return true
+	})
+	r.decoder.ForEachTypedCounter(func(id int64, counterType CounterType, v int64, label string) bool {
+		h.Reset()
+		h.Write([]byte{'c', byte(counterType)})
+		h.Write([]byte(label))
+		h.Write([]byte{0})
+		binary.LittleEndian.PutUint64(value[:], uint64(v))
+		h.Write(value[:])
+		fp += h.Sum64()
+		return true
+	})
+	return fp
+}
+
+// Counter returns a handle to read the counter's value without searching the counter on each read.
+func (r *Reader) Counter(counterID int64) (c *ReaderCounter, err error) {
+	slot, err := r.decoder.FindCounter(counterID)
+	if err != nil {
+		return nil, err
+	}
+	return &ReaderCounter{
+		reader: r,
+		id:     counterID,
+		slot:   slot,
+	}, nil
+}
+
+// GetCounterType returns the type of the counter's value.
+func (r *Reader) GetCounterType(counterID int64) (counterType CounterType, err error) {
+	return r.decoder.GetCounterType(counterID)
+}
+
+// GetFloatCounterValue returns the value of a counter created with Writer.AddFloatCounter.
+func (r *Reader) GetFloatCounterValue(counterID int64) (value float64, err error) {
+	counterType, err := r.decoder.GetCounterType(counterID)
+	if err != nil {
+		return 0, err
+	}
+	if counterType != CounterTypeFloat64 {
+		return 0, fmt.Errorf("counter %d isn't a float counter", counterID)
+	}
+	bits, err := r.decoder.GetCounterValue(counterID)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(uint64(bits)), nil
+}
+
+// MinMax is a snapshot of a counter created with Writer.AddMinMaxCounter.
+type MinMax struct {
+	Value int64
+	Min   int64
+	Max   int64
+	Count int64
+}
+
+// GetCounterMinMax returns the snapshot of a counter created with Writer.AddMinMaxCounter.
+func (r *Reader) GetCounterMinMax(counterID int64) (mm MinMax, err error) {
+	mm.Value, mm.Min, mm.Max, mm.Count, err = r.decoder.GetCounterMinMax(counterID)
+	return mm, err
+}
+
+// IsWritable returns true if the reader was created with NewReaderForFileWritable.
+func (r *Reader) IsWritable() bool {
+	return r.writable
+}
+
+// SetCounterValue sets the value of the counter. It returns an error if the reader isn't writable.
+// Note, the value is overwritten by the writer's process if it modifies the counter concurrently.
+func (r *Reader) SetCounterValue(counterID, value int64) (err error) {
+	if !r.writable {
+		return errors.New("the reader is read-only")
+	}
+	return r.decoder.SetCounterValue(counterID, value)
+}
+
 // GetCounterLabel returns
 func (r *Reader) GetCounterLabel(counterID int64) (label string, err error) {
 	return r.decoder.GetCounterLabel(counterID)
 }
 
-// Close returns
+// CopyBytes returns a copy of the counters' file. Trailing bytes of the mapping
+// which aren't used by the counters' layout aren't copied.
+func (r *Reader) CopyBytes() []byte {
+	if r.buffer == nil { // Created with NewReaderWithBuffers
+		l := r.decoder.Layout
+		bs := make([]byte, 0, r.UsedSize())
+		for _, section := range []*offheap.Buffer{l.Header, l.Statics, l.CountersMetadata, l.CountersValues} {
+			bs = append(bs, section.GetBytes(0, section.Capacity())...)
+		}
+		return bs
+	}
+	return r.buffer.GetBytes(0, r.UsedSize())
+}
+
+// MappedSize returns the number of bytes of the counters' file available to the reader.
+// The file is aligned on the page size, so it's typically larger than UsedSize.
+// For a reader created with NewReaderWithBuffers it's the same as UsedSize.
+func (r *Reader) MappedSize() int {
+	if r.buffer == nil {
+		return r.UsedSize()
+	}
+	return r.buffer.Capacity()
+}
+
+// UsedSize returns the number of bytes occupied by the header, the statics, the metadata and the values.
+func (r *Reader) UsedSize() int {
+	l := r.decoder.Layout
+	return l.Header.Capacity() +
+		l.Statics.Capacity() +
+		l.CountersMetadata.Capacity() +
+		l.CountersValues.Capacity()
+}
+
+// ReaderCounter is a handle to a counter returned by Reader.Counter.
+// It remembers the slot of the counter and searches the counter again only if the slot was reused.
+type ReaderCounter struct {
+	reader *Reader
+	id     int64
+	slot   layout.CounterSlot
+}
+
+// ID returns the id of the counter.
+func (c *ReaderCounter) ID() int64 {
+	return c.id
+}
+
+// Get returns the value of the counter. It returns an error if the counter was freed.
+func (c *ReaderCounter) Get() (value int64, err error) {
+	if value, ok := c.reader.decoder.GetCounterValueAt(c.slot); ok {
+		return value, nil
+	}
+	slot, err := c.reader.decoder.FindCounter(c.id)
+	if err != nil {
+		return 0, err
+	}
+	c.slot = slot
+	return c.Get()
+}
+
+// Close stops the observers and unmaps the buffer if it was mapped by the reader. The observers
+// don't poll the buffer once Close returns, but a callback of an earlier poll may still run,
+// so Close may be called from the callback.
 func (r *Reader) Close() (err error) {
+	r.observeLock.Lock()
+	if r.closed {
+		r.observeLock.Unlock()
+		return nil
+	}
+	r.closed = true
+	if r.observeDone != nil {
+		close(r.observeDone)
+	}
+	r.observeLock.Unlock()
+
+	// The observers, which may be closing the reader from their callbacks, don't read
+	// the buffer once the reader is closed, so the ones still reading are awaited only
+	r.observeReads.Lock()
+	r.observeReads.Unlock()
+
+	if !r.mapped {
+		return nil
+	}
 	return mmap.Unmap(r.buffer)
 }
@@ -0,0 +1,128 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"sync"
+)
+
+// GroupReadersByPid groups the readers by PIDs of the processes which wrote their files.
+func GroupReadersByPid(readers []*Reader) map[int64][]*Reader {
+	groups := make(map[int64][]*Reader)
+	for _, r := range readers {
+		pid := r.Pid()
+		groups[pid] = append(groups[pid], r)
+	}
+	return groups
+}
+
+// DirectoryWatcher maintains open readers for all counters' files in a directory.
+// The directory is rescanned on each call of Refresh.
+type DirectoryWatcher struct {
+	dir     string
+	readers map[string]*Reader // Key is the file's name
+	lock    sync.Mutex
+}
+
+// NewDirectoryWatcher creates new instance of the DirectoryWatcher for the directory specified
+// and opens readers for the files already existing in it.
+func NewDirectoryWatcher(dir string) (w *DirectoryWatcher, err error) {
+	w = &DirectoryWatcher{
+		dir:     dir,
+		readers: make(map[string]*Reader),
+	}
+	if _, _, err = w.Refresh(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Dir returns the watched directory.
+func (w *DirectoryWatcher) Dir() string {
+	return w.dir
+}
+
+// Refresh rescans the directory, opens readers for new files and closes readers of removed ones.
+// A recreated file is reported as both removed and added. Files which cannot be read yet
+// (for example, not initialized by their writers) are skipped and tried again on the next refresh.
+// It returns sorted names of added and removed files.
+func (w *DirectoryWatcher) Refresh() (added, removed []string, err error) {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	existing := make(map[string]bool, len(infos))
+
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		name := fi.Name()
+		existing[name] = true
+
+		if r, has := w.readers[name]; has {
+			if recreated, err := r.DetectRecreation(); err != nil || !recreated {
+				continue
+			}
+			r.Close()
+			delete(w.readers, name)
+			removed = append(removed, name)
+		}
+
+		r, err := NewReaderForFile(path.Join(w.dir, name))
+		if err != nil {
+			continue
+		}
+		w.readers[name] = r
+		added = append(added, name)
+	}
+
+	for name, r := range w.readers {
+		if existing[name] {
+			continue
+		}
+		r.Close()
+		delete(w.readers, name)
+		removed = append(removed, name)
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed, nil
+}
+
+// Readers returns currently open readers by names of their files.
+// A reader must not be used after the refresh which reported its file as removed.
+func (w *DirectoryWatcher) Readers() map[string]*Reader {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	readers := make(map[string]*Reader, len(w.readers))
+	for name, r := range w.readers {
+		readers[name] = r
+	}
+	return readers
+}
+
+// Close closes all open readers.
+func (w *DirectoryWatcher) Close() (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for name, r := range w.readers {
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+		delete(w.readers, name)
+	}
+	return err
+}
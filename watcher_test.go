@@ -0,0 +1,79 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestDirectoryWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestDirectoryWatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w1, err := NewWriterForFile(path.Join(dir, "1.dat"), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w1.Close()
+
+	dw, err := NewDirectoryWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Close()
+
+	if readers := dw.Readers(); len(readers) != 1 || readers["1.dat"] == nil {
+		t.Fatalf("Only 1.dat should be open: %v", readers)
+	}
+
+	w2, err := NewWriterForFile(path.Join(dir, "2.dat"), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	if err := ioutil.WriteFile(path.Join(dir, "garbage.dat"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed, err := dw.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"2.dat"}) || len(removed) != 0 {
+		t.Fatalf("Unexpected changes. Added: %v, removed: %v", added, removed)
+	}
+
+	readers := dw.Readers()
+	var all []*Reader
+	for _, r := range readers {
+		all = append(all, r)
+	}
+	groups := GroupReadersByPid(all)
+	if len(groups) != 1 || len(groups[int64(os.Getpid())]) != 2 {
+		t.Fatalf("Both readers should be grouped by the current PID: %v", groups)
+	}
+
+	if err := os.Remove(path.Join(dir, "1.dat")); err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed, err = dw.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 || !reflect.DeepEqual(removed, []string{"1.dat"}) {
+		t.Fatalf("Unexpected changes. Added: %v, removed: %v", added, removed)
+	}
+	if readers := dw.Readers(); len(readers) != 1 || readers["2.dat"] == nil {
+		t.Fatalf("Only 2.dat should be open: %v", readers)
+	}
+}
@@ -4,9 +4,13 @@
 package mc4go
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
 	"path"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,27 +24,124 @@ const MaxPossibleNumberOfCounters = 10000
 
 // Writer creates a mmap file and writes statics and counters into it.
 type Writer struct {
-	filename   string
-	idSequence int64
-	closed     int32
-	buffer     *offheap.Buffer
-	encoder    *layout.Encoder
-	values     *offheap.Buffer
+	filename         string
+	sharedMemoryName string
+	idSequence       int64
+	closed           int32
+	buffer           *offheap.Buffer
+	encoder          *layout.Encoder
+	values           *offheap.Buffer
+	autoFlushLock    sync.Mutex
+	autoFlushStop    chan struct{}
+	autoFlushDone    chan struct{}
+	autoFlushes      int64 // number of flushes done by the auto-flush goroutine
+	explicitIDs      int64 // number of IDs reserved for AddCounterWithID
+	explicitIDsLock  sync.Mutex
+	strictLabels     bool
+	clock            func() time.Time
+	reservedLock     sync.Mutex
+	reserved         []reservedSlot // slots claimed by Reserve and not used yet
 }
 
+// reservedSlot is a slot claimed by Writer.Reserve for the counter with the id.
+type reservedSlot struct {
+	index int
+	id    int64
+}
+
+// WriterOptions tunes the layout of the counters' file.
+type WriterOptions struct {
+	// ValueStride is the number of bytes occupied by a counter's value: a power of 2 between 8 and 128.
+	// Zero means 128 bytes, which keeps values of different counters on different cache lines.
+	// Smaller strides make the file denser at the cost of false sharing between counters.
+	ValueStride int
+	// ExplicitIDs reserves the IDs from 0 to ExplicitIDs-1 for the counters added with AddCounterWithID.
+	// The IDs of the counters added with other methods are sequenced starting from ExplicitIDs.
+	ExplicitIDs int64
+	// StrictLabels makes adding of a counter fail if its label is longer than MaxLabelLength
+	// instead of truncating the label.
+	StrictLabels bool
+	// Clock returns the current time, for example, the start time of the writer stored in the file.
+	// Nil means time.Now. Tests can set a fixed time to make the file's content deterministic.
+	Clock func() time.Time
+}
+
+// MaxLabelLength is the max number of bytes of a counter's label.
+const MaxLabelLength = layout.MaxLabelLength
+
 // NewWriterForFile creates new instance of the Writer.
 // filename specifies a path to the mmap file.
 // statics contains all static values to be published.
 // maxNumbersOfCounters defines how many counters are going to be created in this file maximum.
 // If the file already exists, the function returns an error.
 func NewWriterForFile(filename string, statics map[string]string, maxNumbersOfCounters int) (w *Writer, err error) {
+	return NewWriterForFileWithOptions(filename, statics, maxNumbersOfCounters, WriterOptions{})
+}
+
+// NewWriterForFileWithOptions creates new instance of the Writer as NewWriterForFile does
+// with the layout tuned by the options.
+func NewWriterForFileWithOptions(filename string, statics map[string]string, maxNumbersOfCounters int,
+	options WriterOptions) (w *Writer, err error) {
+	return newWriterForFile(filename, layout.SortedStatics(statics), maxNumbersOfCounters, options)
+}
+
+// NewWriterForFileOrdered creates new instance of the Writer as NewWriterForFile does,
+// but keeps the statics in the order specified instead of sorting them by the labels.
+// The labels of the statics must be unique.
+func NewWriterForFileOrdered(filename string, statics []Static, maxNumbersOfCounters int) (w *Writer, err error) {
+	pairs := make([][2]string, 0, len(statics))
+	labels := make(map[string]bool, len(statics))
+	for _, s := range statics {
+		if labels[s.Label] {
+			return nil, fmt.Errorf("duplicated label of a static: %s", s.Label)
+		}
+		labels[s.Label] = true
+		pairs = append(pairs, [2]string{s.Label, s.Value})
+	}
+	return newWriterForFile(filename, pairs, maxNumbersOfCounters, WriterOptions{})
+}
+
+func newWriterForFile(filename string, statics [][2]string, maxNumbersOfCounters int,
+	options WriterOptions) (w *Writer, err error) {
+	return newWriter(statics, maxNumbersOfCounters, options, func(size int) (*offheap.Buffer, error) {
+		return mmap.MapNewFile(filename, size)
+	}, func(w *Writer) {
+		w.filename = filename
+	})
+}
+
+// NewWriterForSharedMemory creates new instance of the Writer publishing into a named shared memory segment
+// instead of a file. Readers attach to the segment by the same name with NewReaderForSharedMemory.
+// The segment is unlinked when the writer is closed.
+// If the segment already exists, the function returns an error.
+func NewWriterForSharedMemory(name string, statics map[string]string, maxNumbersOfCounters int) (w *Writer, err error) {
+	return newWriter(layout.SortedStatics(statics), maxNumbersOfCounters, WriterOptions{}, func(size int) (*offheap.Buffer, error) {
+		return mmap.MapNewSharedMemory(name, size)
+	}, func(w *Writer) {
+		w.sharedMemoryName = name
+	})
+}
+
+func newWriter(statics [][2]string, maxNumbersOfCounters int, options WriterOptions,
+	mapNew func(size int) (*offheap.Buffer, error), init func(w *Writer)) (w *Writer, err error) {
 	if maxNumbersOfCounters < 0 || maxNumbersOfCounters > MaxPossibleNumberOfCounters {
 		return nil, fmt.Errorf("Incorrect max numbers of counters: %d", maxNumbersOfCounters)
 	}
 
-	staticsLength := layout.StaticsLength(statics)
+	valueStride := options.ValueStride
+	if valueStride == 0 {
+		valueStride = layout.DefaultValueStride
+	}
+	if err := layout.ValidateValueStride(valueStride); err != nil {
+		return nil, err
+	}
+	if options.ExplicitIDs < 0 {
+		return nil, fmt.Errorf("Incorrect number of explicit IDs: %d", options.ExplicitIDs)
+	}
+
+	staticsLength := layout.StaticsLengthOrdered(statics)
 	metadataLength := layout.MetadataLength(maxNumbersOfCounters)
-	valuesLength := layout.ValuesLength(maxNumbersOfCounters)
+	valuesLength := layout.ValuesLengthWithStride(maxNumbersOfCounters, valueStride)
 
 	countersFileSize := layout.Align(
 		layout.HeaderLength()+
@@ -49,7 +150,7 @@ func NewWriterForFile(filename string, statics map[string]string, maxNumbersOfCo
 			valuesLength,
 		os.Getpagesize())
 
-	buf, err := mmap.MapNewFile(filename, countersFileSize)
+	buf, err := mapNew(countersFileSize)
 	if err != nil {
 		return nil, err
 	}
@@ -58,21 +159,41 @@ func NewWriterForFile(filename string, statics map[string]string, maxNumbersOfCo
 		staticsLength,
 		metadataLength,
 		valuesLength)
+	if err := encoder.Layout.CheckAlignment(); err != nil { // Never happens for mapped pages
+		mmap.Unmap(buf)
+		return nil, err
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = time.Now
+	}
 
 	encoder.SetPid(int64(os.Getpid()))
-	encoder.SetStartTime(time.Now().UnixNano() / int64(time.Millisecond))
-	encoder.SetStatics(statics)
-
-	encoder.SetVersion(layout.CountersVersion)
-
-	return &Writer{
-		filename:   filename,
-		idSequence: -1,
-		closed:     0,
-		buffer:     buf,
-		encoder:    encoder,
-		values:     encoder.Layout.CountersValues,
-	}, nil
+	encoder.SetStartTime(clock().UnixNano() / int64(time.Millisecond))
+	encoder.SetStaticsOrdered(statics)
+
+	encoder.SetStrictLabels(options.StrictLabels)
+
+	if options.ExplicitIDs > 0 {
+		encoder.AddFeatures(layout.FeatureExplicitIDs)
+	}
+
+	encoder.SetVersion(encoder.RequiredVersion())
+
+	w = &Writer{
+		idSequence:   options.ExplicitIDs - 1,
+		closed:       0,
+		buffer:       buf,
+		encoder:      encoder,
+		values:       encoder.Layout.CountersValues,
+		explicitIDs:  options.ExplicitIDs,
+		strictLabels: options.StrictLabels,
+		clock:        clock,
+	}
+	init(w)
+
+	return w, nil
 }
 
 // NewWriterForName creates new instance of the Writer with the given file name.
@@ -85,6 +206,7 @@ func NewWriterForName(name string, statics map[string]string, maxNumbersOfCounte
 }
 
 // Filename returns the path to the counters' file.
+// It's empty if the writer publishes into a shared memory segment.
 func (w *Writer) Filename() (filename string) {
 	return w.filename
 }
@@ -101,20 +223,222 @@ func (w *Writer) AddCounter(label string) (c *Counter, err error) {
 
 // AddCounterWithInitialValue creates and returns new counter with the label and initial value specified.
 func (w *Writer) AddCounterWithInitialValue(label string, initialValue int64) (c *Counter, err error) {
+	return w.addCounter(label, layout.CounterTypeInt64, initialValue)
+}
+
+// AddFloatCounter creates and returns new counter with float64 value and the label and initial value specified.
+func (w *Writer) AddFloatCounter(label string, initialValue float64) (c *FloatCounter, err error) {
+	counter, err := w.addCounter(label, layout.CounterTypeFloat64, int64(math.Float64bits(initialValue)))
+	if err != nil {
+		return nil, err
+	}
+	return &FloatCounter{
+		counter: counter,
+	}, nil
+}
+
+// AddMinMaxCounter creates and returns new counter tracking the min, the max and the count of the values.
+func (w *Writer) AddMinMaxCounter(label string) (c *MinMaxCounter, err error) {
+	counter, err := w.addCounter(label, layout.CounterTypeMinMax, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &MinMaxCounter{
+		counter: counter,
+	}, nil
+}
+
+// AddCounterWithID creates and returns new counter with the ID reserved with WriterOptions.ExplicitIDs.
+func (w *Writer) AddCounterWithID(id int64, label string, initialValue int64) (c *Counter, err error) {
+	return w.addCounterWithID(id, label, layout.CounterTypeInt64, initialValue)
+}
+
+func (w *Writer) addCounterWithID(id int64, label string, counterType layout.CounterType, initialValue int64) (c *Counter, err error) {
+	if id < 0 || id >= w.explicitIDs {
+		return nil, fmt.Errorf("counter ID %d isn't in the range of the explicit IDs [0, %d)", id, w.explicitIDs)
+	}
+
+	w.explicitIDsLock.Lock()
+	defer w.explicitIDsLock.Unlock()
+
+	valueOffset, err := w.encoder.AddTypedCounterUnique(id, counterType, initialValue, label)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.newCounter(id, label, counterType, valueOffset), nil
+}
+
+func (w *Writer) addCounter(label string, counterType layout.CounterType, initialValue int64) (c *Counter, err error) {
 	id := atomic.AddInt64(&w.idSequence, 1)
 
-	valueOffset, err := w.encoder.AddCounter(id, initialValue, label)
+	valueOffset, err := w.encoder.AddTypedCounter(id, counterType, initialValue, label)
 	if err != nil {
 		return nil, err
 	}
 
+	return w.newCounter(id, label, counterType, valueOffset), nil
+}
+
+// Reserve claims n free slots for the counters to be added later with UseReserved, so adding
+// of these counters can't fail for lack of space. The slots are skipped by the readers until used.
+// If there isn't enough free slots, the claimed ones stay reserved and an error is returned.
+func (w *Writer) Reserve(n int) (err error) {
+	w.reservedLock.Lock()
+	defer w.reservedLock.Unlock()
+
+	for i := 0; i < n; i++ {
+		id := atomic.AddInt64(&w.idSequence, 1)
+		index, err := w.encoder.ReserveSlot(id)
+		if err != nil {
+			return fmt.Errorf("%d of %d slots reserved: %v", i, n, err)
+		}
+		w.reserved = append(w.reserved, reservedSlot{index: index, id: id})
+	}
+	return nil
+}
+
+// Reserved returns the number of slots reserved with Reserve and not used yet.
+func (w *Writer) Reserved() int {
+	w.reservedLock.Lock()
+	defer w.reservedLock.Unlock()
+	return len(w.reserved)
+}
+
+// UseReserved creates and returns new counter with the label and initial value specified
+// in a slot claimed with Reserve without searching for a free slot. It returns an error
+// if no reserved slots are left.
+func (w *Writer) UseReserved(label string, initialValue int64) (c *Counter, err error) {
+	w.reservedLock.Lock()
+	defer w.reservedLock.Unlock()
+
+	if len(w.reserved) == 0 {
+		return nil, errors.New("no reserved slots left")
+	}
+	slot := w.reserved[0]
+
+	valueOffset, err := w.encoder.AllocateReserved(slot.index, slot.id, layout.CounterTypeInt64, initialValue, label)
+	if err != nil {
+		return nil, err
+	}
+	w.reserved = w.reserved[1:]
+
+	return w.newCounter(slot.id, label, layout.CounterTypeInt64, valueOffset), nil
+}
+
+func (w *Writer) newCounter(id int64, label string, counterType layout.CounterType, valueOffset uintptr) *Counter {
 	return &Counter{
 		owner:       w,
 		id:          id,
 		label:       label,
+		counterType: counterType,
 		valueOffset: valueOffset,
 		closed:      0,
-	}, nil
+	}
+}
+
+// Namespace returns a factory creating counters with labels prefixed with "prefix.".
+func (w *Writer) Namespace(prefix string) *CounterFactory {
+	return &CounterFactory{
+		owner:  w,
+		prefix: prefix,
+	}
+}
+
+// CounterFactory creates counters of the writer within a namespace.
+type CounterFactory struct {
+	owner  *Writer
+	prefix string
+}
+
+// Prefix returns the namespace of the counters created by the factory.
+func (f *CounterFactory) Prefix() string {
+	return f.prefix
+}
+
+// Namespace returns a factory creating counters within the nested namespace "prefix.name".
+func (f *CounterFactory) Namespace(name string) *CounterFactory {
+	return f.owner.Namespace(f.label(name))
+}
+
+// AddCounter creates and returns new counter with the label "prefix.name".
+func (f *CounterFactory) AddCounter(name string) (c *Counter, err error) {
+	return f.owner.AddCounter(f.label(name))
+}
+
+// AddCounterWithInitialValue creates and returns new counter with the label "prefix.name" and the initial value specified.
+func (f *CounterFactory) AddCounterWithInitialValue(name string, initialValue int64) (c *Counter, err error) {
+	return f.owner.AddCounterWithInitialValue(f.label(name), initialValue)
+}
+
+// AddFloatCounter creates and returns new float64 counter with the label "prefix.name" and the initial value specified.
+func (f *CounterFactory) AddFloatCounter(name string, initialValue float64) (c *FloatCounter, err error) {
+	return f.owner.AddFloatCounter(f.label(name), initialValue)
+}
+
+func (f *CounterFactory) label(name string) string {
+	return f.prefix + "." + name
+}
+
+// Rotate creates a writer of the new file with the same statics, capacity and value stride
+// and copies the counters with their current values into it. The copied counters get new IDs,
+// except the ones with explicit IDs, so the new counters are returned by the IDs of the copied ones
+// to re-bind the handles.
+// Float counters are copied as well and are returned as counters of their values' raw bits.
+// Min/max counters are copied with their min, max and count; Counter.MinMax returns their handles.
+// The new writer reserves as many slots as left reserved with Reserve in this writer.
+// The writer stays open, so its file can be archived after the writer is closed.
+func (w *Writer) Rotate(newFilename string) (nw *Writer, counters map[int64]*Counter, err error) {
+	if w.IsClosed() {
+		return nil, nil, errors.New("the writer is closed")
+	}
+
+	d := layout.NewDecoder(w.buffer)
+
+	nw, err = newWriterForFile(newFilename, d.StaticsInto(nil),
+		layout.MaxCounters(d.Layout.CountersMetadata.Capacity()),
+		WriterOptions{ValueStride: d.ValueStride(), ExplicitIDs: w.explicitIDs, StrictLabels: w.strictLabels,
+			Clock: w.clock})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counters = make(map[int64]*Counter)
+	d.ForEachTypedCounter(func(id int64, counterType layout.CounterType, value int64, label string) bool {
+		var c *Counter
+		if id < w.explicitIDs {
+			c, err = nw.addCounterWithID(id, label, counterType, value)
+		} else {
+			c, err = nw.addCounter(label, counterType, value)
+		}
+		if err != nil {
+			return false
+		}
+		if counterType == layout.CounterTypeMinMax {
+			if _, min, max, count, mmErr := d.GetCounterMinMax(id); mmErr == nil {
+				nw.values.PutInt64Volatile(c.valueOffset+layout.ValueMinOffset, min)
+				nw.values.PutInt64Volatile(c.valueOffset+layout.ValueMaxOffset, max)
+				nw.values.PutInt64Volatile(c.valueOffset+layout.ValueCountOffset, count)
+			}
+		}
+		counters[id] = c
+		return true
+	})
+	if err == nil {
+		err = nw.Reserve(w.Reserved())
+	}
+	if err != nil {
+		nw.Close()
+		os.Remove(newFilename)
+		return nil, nil, err
+	}
+
+	return nw, counters, nil
+}
+
+// BumpEpoch increments the epoch of the values and returns the new one.
+func (w *Writer) BumpEpoch() int64 {
+	return w.encoder.BumpEpoch()
 }
 
 // IsClosed returns true if the writer was closed.
@@ -122,12 +446,94 @@ func (w *Writer) IsClosed() bool {
 	return atomic.LoadInt32(&w.closed) != 0
 }
 
+// NewReader creates a reader which maps the writer's file or shared memory segment read-only,
+// so it's validated as any other reader of the counters. The reader must be closed separately.
+func (w *Writer) NewReader() (r *Reader, err error) {
+	if w.IsClosed() {
+		return nil, errors.New("the writer is closed")
+	}
+	if w.sharedMemoryName != "" {
+		return NewReaderForSharedMemory(w.sharedMemoryName)
+	}
+	return NewReaderForFile(w.filename)
+}
+
+// Flush writes the counters' file to the disk synchronously.
+func (w *Writer) Flush() (err error) {
+	if w.IsClosed() {
+		return errors.New("the writer is closed")
+	}
+	return mmap.Flush(w.buffer)
+}
+
+// StartAutoFlush starts a goroutine which flushes the counters' file with the interval specified
+// until StopAutoFlush or Close is called. If the auto-flush is already started, it's restarted
+// with the new interval.
+func (w *Writer) StartAutoFlush(interval time.Duration) {
+	w.autoFlushLock.Lock()
+	defer w.autoFlushLock.Unlock()
+
+	w.stopAutoFlush()
+
+	if w.IsClosed() {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	w.autoFlushStop = stop
+	w.autoFlushDone = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := mmap.Flush(w.buffer); err != nil {
+					log.Printf("mc4go: auto-flush failed: %v", err)
+				}
+				atomic.AddInt64(&w.autoFlushes, 1)
+			}
+		}
+	}()
+}
+
+// StopAutoFlush stops the goroutine started by StartAutoFlush and waits for it to exit.
+func (w *Writer) StopAutoFlush() {
+	w.autoFlushLock.Lock()
+	defer w.autoFlushLock.Unlock()
+
+	w.stopAutoFlush()
+}
+
+func (w *Writer) stopAutoFlush() {
+	if w.autoFlushStop == nil {
+		return
+	}
+	close(w.autoFlushStop)
+	<-w.autoFlushDone
+	w.autoFlushStop = nil
+	w.autoFlushDone = nil
+}
+
 // Close closes the writer and unmaps previously mapped counters' file.
+// If the writer publishes into a shared memory segment, the segment is unlinked.
 func (w *Writer) Close() (err error) {
 	if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
 		return
 	}
-	return mmap.Unmap(w.buffer)
+	w.StopAutoFlush()
+	err = mmap.Unmap(w.buffer)
+	if w.sharedMemoryName != "" {
+		if unlinkErr := mmap.UnlinkSharedMemory(w.sharedMemoryName); err == nil {
+			err = unlinkErr
+		}
+	}
+	return err
 }
 
 // Counter presents. Note, that the counter cannot be used after the writer is closed,
@@ -136,8 +542,10 @@ type Counter struct {
 	owner       *Writer
 	id          int64
 	label       string
+	counterType layout.CounterType
 	valueOffset uintptr
 	closed      int32
+	onClose     func()
 }
 
 // ID returns ID of the counter. ID is unique for the process.
@@ -180,6 +588,16 @@ func (c *Counter) GetAndAdd(delta int64) int64 {
 	return c.owner.values.AddInt64(c.valueOffset, delta) - delta
 }
 
+// MinMax returns the counter as a min/max counter or nil if it isn't one.
+func (c *Counter) MinMax() *MinMaxCounter {
+	if c.counterType != layout.CounterTypeMinMax {
+		return nil
+	}
+	return &MinMaxCounter{
+		counter: c,
+	}
+}
+
 // IsClosed returns true if the counter was closed.
 func (c *Counter) IsClosed() bool {
 	return atomic.LoadInt32(&c.closed) != 0
@@ -191,4 +609,116 @@ func (c *Counter) Close() {
 		return
 	}
 	c.owner.encoder.FreeCounter(c.id)
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// OnClose sets the function called once the counter is closed and its slot is freed.
+// It must not be called concurrently with Close.
+func (c *Counter) OnClose(f func()) {
+	c.onClose = f
+}
+
+// FloatCounter presents a counter with float64 value.
+type FloatCounter struct {
+	counter *Counter
+}
+
+// ID returns ID of the counter. ID is unique for the process.
+func (c *FloatCounter) ID() int64 {
+	return c.counter.ID()
+}
+
+// Label returns the label of the counter.
+func (c *FloatCounter) Label() string {
+	return c.counter.Label()
+}
+
+// Get returns the value of the counter with volatile semantic.
+func (c *FloatCounter) Get() float64 {
+	return c.counter.owner.values.GetFloat64Volatile(c.counter.valueOffset)
+}
+
+// Set sets the value of the counter with volatile semantic.
+func (c *FloatCounter) Set(v float64) {
+	c.counter.owner.values.PutFloat64Volatile(c.counter.valueOffset, v)
+}
+
+// Add atomically adds a delta to the value of the counter and returns the new value.
+func (c *FloatCounter) Add(delta float64) float64 {
+	return c.counter.owner.values.AddFloat64(c.counter.valueOffset, delta)
+}
+
+// IsClosed returns true if the counter was closed.
+func (c *FloatCounter) IsClosed() bool {
+	return c.counter.IsClosed()
+}
+
+// Close closes the counter and frees its memory slot.
+func (c *FloatCounter) Close() {
+	c.counter.Close()
+}
+
+// OnClose sets the function called once the counter is closed and its slot is freed.
+// It must not be called concurrently with Close.
+func (c *FloatCounter) OnClose(f func()) {
+	c.counter.OnClose(f)
+}
+
+// MinMaxCounter presents a counter tracking the min, the max and the count of the values.
+type MinMaxCounter struct {
+	counter *Counter
+}
+
+// ID returns ID of the counter. ID is unique for the process.
+func (c *MinMaxCounter) ID() int64 {
+	return c.counter.ID()
+}
+
+// Label returns the label of the counter.
+func (c *MinMaxCounter) Label() string {
+	return c.counter.Label()
+}
+
+// Get returns the last recorded value with volatile semantic.
+func (c *MinMaxCounter) Get() int64 {
+	return c.counter.Get()
+}
+
+// Record sets the value of the counter and atomically updates the min, the max and the count.
+func (c *MinMaxCounter) Record(v int64) {
+	values := c.counter.owner.values
+	offset := c.counter.valueOffset
+
+	values.PutInt64Volatile(offset, v)
+	for {
+		min := values.GetInt64Volatile(offset + layout.ValueMinOffset)
+		if v >= min || values.CompareAndSwapInt64(offset+layout.ValueMinOffset, min, v) {
+			break
+		}
+	}
+	for {
+		max := values.GetInt64Volatile(offset + layout.ValueMaxOffset)
+		if v <= max || values.CompareAndSwapInt64(offset+layout.ValueMaxOffset, max, v) {
+			break
+		}
+	}
+	values.AddInt64(offset+layout.ValueCountOffset, 1)
+}
+
+// IsClosed returns true if the counter was closed.
+func (c *MinMaxCounter) IsClosed() bool {
+	return c.counter.IsClosed()
+}
+
+// Close closes the counter and frees its memory slot.
+func (c *MinMaxCounter) Close() {
+	c.counter.Close()
+}
+
+// OnClose sets the function called once the counter is closed and its slot is freed.
+// It must not be called concurrently with Close.
+func (c *MinMaxCounter) OnClose(f func()) {
+	c.counter.OnClose(f)
 }
@@ -0,0 +1,298 @@
+// Copyright (c) 2020 anatolygudkov. All rights reserved.
+// Use of this source code is governed by MIT license
+// that can be found in the LICENSE file.
+package mc4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSharedMemory(t *testing.T) {
+	name := "goTestSharedMemory"
+
+	statics := map[string]string{"static": "value"}
+
+	w, err := NewWriterForSharedMemory(name, statics, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	cnt, err := w.AddCounterWithInitialValue(counterPrefix, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForSharedMemory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.GetStaticValue("static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != statics["static"] {
+		t.Fatalf("Got static value %s, expected %s", v, statics["static"])
+	}
+
+	value, err := r.GetCounterValue(cnt.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 10 {
+		t.Fatalf("Got counter value %d, expected %d", value, 10)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReaderForSharedMemory(name); err == nil {
+		t.Fatal("The shared memory segment must be unlinked on close")
+	}
+}
+
+func TestLeakedReaderUnmapped(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestLeakedReaderUnmapped.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	isMapped := func() bool {
+		maps, err := ioutil.ReadFile("/proc/self/maps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(string(maps), filename)
+	}
+
+	// A closed reader must not be unmapped again, when the address may be taken by another mapping
+	closed, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	closed = nil
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	if r.Version() == 0 {
+		t.Fatal("The reader must stay mapped")
+	}
+	if !isMapped() {
+		t.Fatal("The file must be mapped")
+	}
+
+	r = nil
+	for i := 0; i < 10 && isMapped(); i++ {
+		runtime.GC()
+		runtime.Gosched()
+	}
+	if isMapped() {
+		t.Fatal("The file of the leaked reader must be unmapped")
+	}
+}
+
+func TestPruneStaleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestPruneStaleFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The pid of a finished child process isn't used by any running process for a while
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadPid := int64(cmd.ProcessState.Pid())
+
+	live := path.Join(dir, "live.dat")
+	dead := path.Join(dir, "dead.dat")
+	garbage := path.Join(dir, "garbage.dat")
+
+	for _, filename := range []string{live, dead} {
+		w, err := NewWriterForFile(filename, map[string]string{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filename == dead {
+			w.encoder.SetPid(deadPid)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(garbage, []byte("not a counters' file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alive, err := r.IsWriterAlive(); err != nil || alive {
+		t.Fatalf("The writer must be dead, got %t (%v)", alive, err)
+	}
+	r.Close()
+
+	removed, err := PruneStaleFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{dead}) {
+		t.Fatalf("Got removed %v, expected %v", removed, []string{dead})
+	}
+	for _, filename := range []string{live, garbage} {
+		if _, err := os.Stat(filename); err != nil {
+			t.Fatalf("The file %s must be kept: %v", filename, err)
+		}
+	}
+}
+
+func TestDetectRecreation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestDetectRecreation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "counters.dat")
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	dw, err := NewDirectoryWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dw.Close()
+
+	if recreated, err := r.DetectRecreation(); err != nil || recreated {
+		t.Fatalf("The file isn't recreated yet, got %t (%v)", recreated, err)
+	}
+
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DetectRecreation(); err == nil {
+		t.Fatal("An error expected for the removed file")
+	}
+
+	w2, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	if recreated, err := r.DetectRecreation(); err != nil || !recreated {
+		t.Fatalf("The file is recreated, got %t (%v)", recreated, err)
+	}
+
+	added, removed, err := dw.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"counters.dat"}) || !reflect.DeepEqual(removed, []string{"counters.dat"}) {
+		t.Fatalf("Unexpected changes. Added: %v, removed: %v", added, removed)
+	}
+	if recreated, err := dw.Readers()["counters.dat"].DetectRecreation(); err != nil || recreated {
+		t.Fatalf("The watcher must open the new file, got %t (%v)", recreated, err)
+	}
+
+	c, err := NewReaderForBytes(r.CopyBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DetectRecreation(); err == nil {
+		t.Fatal("An error expected for the reader not created for a file")
+	}
+}
+
+// benchmarkFirstScan measures the first iteration over 10000 counters of a freshly opened reader.
+func benchmarkFirstScan(b *testing.B, options ReaderOptions) {
+	numberOfCounters := 10000
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goBenchmarkFirstScan.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, numberOfCounters)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := 0; i < numberOfCounters; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		r, err := NewReaderForFileWithOptions(filename, options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		n := 0
+		r.ForEachCounter(func(id, value int64, label string) bool {
+			n++
+			return true
+		})
+
+		b.StopTimer()
+		r.Close()
+		if n != numberOfCounters {
+			b.Fatalf("Got %d counters, expected %d", n, numberOfCounters)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkFirstScan(b *testing.B) {
+	benchmarkFirstScan(b, ReaderOptions{})
+}
+
+func BenchmarkFirstScanPrefetched(b *testing.B) {
+	benchmarkFirstScan(b, ReaderOptions{Prefetch: true})
+}
@@ -4,37 +4,2359 @@
 package mc4go
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/anatolygudkov/mc4go/internal/layout"
+	"github.com/anatolygudkov/mc4go/internal/offheap"
+)
+
+const (
+	propertyPrefix = "property"
+	valuePrefix    = "value"
+	counterPrefix  = "counter"
 )
 
-const (
-	propertyPrefix = "property"
-	valuePrefix    = "value"
-	counterPrefix  = "counter"
-)
+func TestFull(t *testing.T) {
+	numberOfStatics := 1000
+	numberOfCounters := 1000
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestFull.dat")
+	_, err := os.Stat(filename)
+	if err == nil {
+		if os.Remove(filename) != nil {
+			t.Fatal(err)
+		}
+	}
+
+	statics := make(map[string]string)
+	for i := 0; i < numberOfStatics; i++ {
+		statics[fmt.Sprintf("%s%d", propertyPrefix, i)] = fmt.Sprintf("%s%d", valuePrefix, i)
+	}
+
+	w, err := NewWriterForFile(filename, statics, numberOfCounters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var counters []*Counter
+
+	for i := 0; i < numberOfCounters; i++ {
+		counter, err := w.AddCounter(fmt.Sprintf("%s%d", counterPrefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counters = append(counters, counter)
+
+		counter.Set(counter.id - 1)
+		counter.Increment()
+	}
+
+	numOfStaticsCounted := 0
+
+	r.ForEachStatic(func(label, value string) bool {
+		staticLabel := fmt.Sprintf("%s%d", propertyPrefix, numOfStaticsCounted)
+
+		expectedValue := fmt.Sprintf("%s%d", valuePrefix, numOfStaticsCounted)
+		readValue := statics[staticLabel]
+
+		if readValue != expectedValue {
+			t.Fatalf("Read static value %s, expected %s", readValue, expectedValue)
+		}
+
+		gotValue, err := r.GetStaticValue(staticLabel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readValue != expectedValue {
+			t.Fatalf("Got static value %s, expected %s", gotValue, expectedValue)
+		}
+
+		delete(statics, staticLabel)
+
+		numOfStaticsCounted++
+
+		return true
+	})
+	if numOfStaticsCounted != numberOfStatics {
+		t.Fatalf("Statics counted %d, expected %d", numOfStaticsCounted, numberOfStatics)
+	}
+	if len(statics) != 0 {
+		t.Fatal("All statics must be removed")
+	}
+
+	numOfCountersCounted := 0
+
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		counterLabel := fmt.Sprintf("%s%d", counterPrefix, value)
+
+		if label != counterLabel {
+			t.Fatalf("Got counter label %s, expected %s", label, counterLabel)
+		}
+
+		foundLabel, err := r.GetCounterLabel(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if foundLabel != label {
+			t.Fatalf("Found counter label %s, expected %s", foundLabel, label)
+		}
+
+		numOfCountersCounted++
+
+		return true
+	})
+	if numOfCountersCounted != numberOfCounters {
+		t.Fatalf("Counters counted %d, expected %d", numOfCountersCounted, numberOfCounters)
+	}
+
+	for _, c := range counters {
+		if c.IsClosed() {
+			t.Error("The counter must not be closed")
+		}
+		c.Close()
+		if !c.IsClosed() {
+			t.Fatal("The counter must be closed")
+		}
+	}
+
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		t.Error("No counters must be available")
+		return true
+	})
+}
+
+func TestMCountersDirectoryPath(t *testing.T) {
+	if p := path.Base(GetMCountersDirectoryPathFor("", "")); p != "mcounters-default" {
+		t.Fatalf("Got directory %s, expected %s", p, "mcounters-default")
+	}
+	if p := path.Base(GetMCountersDirectoryPathFor("app", "")); p != "app-default" {
+		t.Fatalf("Got directory %s, expected %s", p, "app-default")
+	}
+
+	t.Setenv("mcounters.prefix", "goTestPrefix")
+
+	p := GetMCountersDirectoryPath()
+	if !strings.HasPrefix(path.Base(p), "goTestPrefix-") {
+		t.Fatalf("Directory %s should have the prefix %s", p, "goTestPrefix-")
+	}
+
+	t.Setenv("mcounters.dir", "/tmp/goTestDir")
+
+	if p := GetMCountersDirectoryPath(); p != "/tmp/goTestDir" {
+		t.Fatalf("Got directory %s, expected %s", p, "/tmp/goTestDir")
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+	numberOfCounters := 10
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestFileInfo.dat")
+	os.Remove(filename)
+
+	statics := map[string]string{"static1": "value1", "static2": "value2"}
+
+	w, err := NewWriterForFile(filename, statics, numberOfCounters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fi := r.FileInfo()
+
+	if fi.Version != layout.CountersVersion {
+		t.Fatalf("Version %d, expected %d", fi.Version, layout.CountersVersion)
+	}
+	if expected := layout.StaticsLength(statics); fi.StaticsLength != expected {
+		t.Fatalf("Statics length %d, expected %d", fi.StaticsLength, expected)
+	}
+	if expected := layout.MetadataLength(numberOfCounters); fi.MetadataLength != expected {
+		t.Fatalf("Metadata length %d, expected %d", fi.MetadataLength, expected)
+	}
+	if expected := layout.ValuesLength(numberOfCounters); fi.ValuesLength != expected {
+		t.Fatalf("Values length %d, expected %d", fi.ValuesLength, expected)
+	}
+	if fi.MaxCounters != numberOfCounters {
+		t.Fatalf("Max counters %d, expected %d", fi.MaxCounters, numberOfCounters)
+	}
+	if fi.ValueStride != layout.DefaultValueStride {
+		t.Fatalf("Value stride %d, expected %d", fi.ValueStride, layout.DefaultValueStride)
+	}
+}
+
+func TestMappedUsedSize(t *testing.T) {
+	numberOfCounters := 10
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestMappedUsedSize.dat")
+	os.Remove(filename)
+
+	statics := map[string]string{"static": "value"}
+
+	w, err := NewWriterForFile(filename, statics, numberOfCounters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	used := layout.HeaderLength() +
+		layout.StaticsLength(statics) +
+		layout.MetadataLength(numberOfCounters) +
+		layout.ValuesLength(numberOfCounters)
+	if r.UsedSize() != used {
+		t.Fatalf("Used size %d, expected %d", r.UsedSize(), used)
+	}
+	if mapped := layout.Align(used, os.Getpagesize()); r.MappedSize() != mapped {
+		t.Fatalf("Mapped size %d, expected %d", r.MappedSize(), mapped)
+	}
+
+	c, err := NewReaderForBytes(r.CopyBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.UsedSize() != used || c.MappedSize() != used {
+		t.Fatalf("Used size %d, mapped size %d of the copy, expected %d", c.UsedSize(), c.MappedSize(), used)
+	}
+}
+
+func TestValueStride(t *testing.T) {
+	numberOfCounters := 20
+	valueStride := 8
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestValueStride.dat")
+	os.Remove(filename)
+
+	if _, err := NewWriterForFileWithOptions(filename, nil, numberOfCounters, WriterOptions{ValueStride: 12}); err == nil {
+		t.Fatal("Incorrect stride must be rejected")
+	}
+
+	w, err := NewWriterForFileWithOptions(filename, nil, numberOfCounters, WriterOptions{ValueStride: valueStride})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	counters := make([]*Counter, numberOfCounters-1)
+	for i := range counters {
+		if counters[i], err = w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fc, err := w.AddFloatCounter(counterPrefix+"float", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range counters {
+		c.GetAndAdd(1000)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fi := r.FileInfo()
+	if fi.ValueStride != valueStride {
+		t.Fatalf("Value stride %d, expected %d", fi.ValueStride, valueStride)
+	}
+	if fi.Version != layout.CountersVersionExtended {
+		t.Fatalf("Version %d, expected %d", fi.Version, layout.CountersVersionExtended)
+	}
+	if expected := numberOfCounters * valueStride; fi.ValuesLength != expected {
+		t.Fatalf("Values length %d, expected %d", fi.ValuesLength, expected)
+	}
+
+	for i, c := range counters {
+		expected := int64(i + 1000)
+		if v, err := r.GetCounterValue(c.ID()); err != nil || v != expected {
+			t.Fatalf("Counter %d: got %d (%v), expected %d", c.ID(), v, err, expected)
+		}
+		rc, err := r.Counter(c.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := rc.Get(); err != nil || v != expected {
+			t.Fatalf("Counter %d: got %d (%v), expected %d", c.ID(), v, err, expected)
+		}
+	}
+	if v, err := r.GetFloatCounterValue(fc.ID()); err != nil || v != 0.5 {
+		t.Fatalf("Float counter: got %f (%v), expected %f", v, err, 0.5)
+	}
+
+	n := 0
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		if id != fc.ID() && value != id+1000 {
+			t.Fatalf("Counter %d: got %d, expected %d", id, value, id+1000)
+		}
+		n++
+		return true
+	})
+	if n != numberOfCounters {
+		t.Fatalf("%d counters iterated, expected %d", n, numberOfCounters)
+	}
+}
+
+func TestStaticsInto(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestStaticsInto.dat")
+	os.Remove(filename)
+
+	statics := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		statics[fmt.Sprintf("%s%d", propertyPrefix, i)] = fmt.Sprintf("%s%d", valuePrefix, i)
+	}
+
+	w, err := NewWriterForFile(filename, statics, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var expected [][2]string
+	r.ForEachStatic(func(label, value string) bool {
+		expected = append(expected, [2]string{label, value})
+		return true
+	})
+
+	dst := r.StaticsInto(nil)
+	for i := 0; i < 2; i++ {
+		if len(dst) != len(expected) {
+			t.Fatalf("Got %d statics, expected %d", len(dst), len(expected))
+		}
+		for j := range expected {
+			if dst[j] != expected[j] {
+				t.Fatalf("Got static %v, expected %v", dst[j], expected[j])
+			}
+		}
+		dst = r.StaticsInto(dst[:0])
+	}
+}
+
+func TestOrderedStatics(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestOrderedStatics.dat")
+	os.Remove(filename)
+
+	statics := []Static{
+		{Label: "zone", Value: "eu-1"},
+		{Label: "app", Value: "test"},
+		{Label: "mode", Value: "fast"},
+		{Label: "build", Value: "1.0"},
+	}
+
+	w, err := NewWriterForFileOrdered(filename, statics, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var read []Static
+	r.ForEachStatic(func(label, value string) bool {
+		read = append(read, Static{Label: label, Value: value})
+		return true
+	})
+	if len(read) != len(statics) {
+		t.Fatalf("Got %d statics, expected %d", len(read), len(statics))
+	}
+	for i := range statics {
+		if read[i] != statics[i] {
+			t.Fatalf("Got static %v at %d, expected %v", read[i], i, statics[i])
+		}
+	}
+
+	if _, err := NewWriterForFileOrdered(filename+".dup", []Static{{"a", "1"}, {"a", "2"}}, 0); err == nil {
+		os.Remove(filename + ".dup")
+		t.Fatal("Duplicated labels must be rejected")
+	}
+}
+
+func TestGetStaticValueFold(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestGetStaticValueFold.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"region": "eu-1", "zone": "a"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.GetStaticValue("Region"); err == nil {
+		t.Fatal("The exact lookup must not ignore case")
+	}
+	if v, err := r.GetStaticValueFold("Region"); err != nil || v != "eu-1" {
+		t.Fatalf("Got %s (%v), expected eu-1", v, err)
+	}
+	if _, err := r.GetStaticValueFold("Regions"); err == nil {
+		t.Fatal("An unknown label must not be found")
+	}
+}
+
+func BenchmarkStaticsInto(b *testing.B) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goBenchmarkStaticsInto.dat")
+	os.Remove(filename)
+
+	statics := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		statics[fmt.Sprintf("%s%d", propertyPrefix, i)] = fmt.Sprintf("%s%d", valuePrefix, i)
+	}
+
+	w, err := NewWriterForFile(filename, statics, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	var dst [][2]string
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = r.StaticsInto(dst[:0])
+	}
+}
+
+func TestFloatCounter(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestFloatCounter.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	intCnt, err := w.AddCounter(counterPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	floatCnt, err := w.AddFloatCounter(counterPrefix, 1.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ, err := r.GetCounterType(intCnt.ID()); err != nil || typ != CounterTypeInt64 {
+		t.Fatalf("Got counter type %d (%v), expected %d", typ, err, CounterTypeInt64)
+	}
+	if typ, err := r.GetCounterType(floatCnt.ID()); err != nil || typ != CounterTypeFloat64 {
+		t.Fatalf("Got counter type %d (%v), expected %d", typ, err, CounterTypeFloat64)
+	}
+	if _, err := r.GetFloatCounterValue(intCnt.ID()); err == nil {
+		t.Fatal("An int counter must not be read as a float one")
+	}
+
+	for _, expected := range []float64{1.25, -0.5, 1e300, math.SmallestNonzeroFloat64} {
+		floatCnt.Set(expected)
+
+		value, err := r.GetFloatCounterValue(floatCnt.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected || floatCnt.Get() != expected {
+			t.Fatalf("Got counter value %v, expected %v", value, expected)
+		}
+	}
+
+	floatCnt.Set(0)
+
+	goroutines := 4
+	iterations := 10000
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			for j := 0; j < iterations; j++ {
+				floatCnt.Add(0.5)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	expected := 0.5 * float64(goroutines*iterations)
+	value, err := r.GetFloatCounterValue(floatCnt.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != expected {
+		t.Fatalf("Got counter value %v, expected %v", value, expected)
+	}
+}
+
+func TestCopyBytes(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestCopyBytes.dat")
+	os.Remove(filename)
+
+	statics := map[string]string{"static1": "value1", "static2": "value2"}
+
+	w, err := NewWriterForFile(filename, statics, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b := r.CopyBytes()
+	if len(b) >= r.buffer.Capacity() {
+		t.Fatalf("Unused pages must not be copied: %d of %d bytes copied", len(b), r.buffer.Capacity())
+	}
+
+	cr, err := NewReaderForBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+
+	if cr.Pid() != r.Pid() || cr.StartTime() != r.StartTime() {
+		t.Fatal("Copied header doesn't match the original one")
+	}
+
+	var expectedStatics, copiedStatics [][2]string
+	expectedStatics = r.StaticsInto(expectedStatics)
+	copiedStatics = cr.StaticsInto(copiedStatics)
+	if !reflect.DeepEqual(copiedStatics, expectedStatics) {
+		t.Fatalf("Got statics %v, expected %v", copiedStatics, expectedStatics)
+	}
+
+	var expectedCounters, copiedCounters []string
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		expectedCounters = append(expectedCounters, fmt.Sprintf("%d:%s=%d", id, label, value))
+		return true
+	})
+	cr.ForEachCounter(func(id, value int64, label string) bool {
+		copiedCounters = append(copiedCounters, fmt.Sprintf("%d:%s=%d", id, label, value))
+		return true
+	})
+	if len(expectedCounters) != 5 || !reflect.DeepEqual(copiedCounters, expectedCounters) {
+		t.Fatalf("Got counters %v, expected %v", copiedCounters, expectedCounters)
+	}
+
+	if _, err := NewReaderForBytes(b[:len(b)/2]); err == nil {
+		t.Fatal("Truncated bytes must be rejected")
+	}
+}
+
+func TestReaderCounter(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestReaderCounter.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	c1, err := w.AddCounterWithInitialValue(counterPrefix+"1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rc1, err := r.Counter(c1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i < 5; i++ {
+		c1.Set(i)
+		if v, err := rc1.Get(); err != nil || v != i {
+			t.Fatalf("Got %d (%v), expected %d", v, err, i)
+		}
+	}
+
+	// The slot of the freed counter is reused by the next one
+	c1.Close()
+	c2, err := w.AddCounterWithInitialValue(counterPrefix+"2", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := rc1.Get(); err == nil {
+		t.Fatalf("The freed counter must not be read, got %d", v)
+	}
+
+	rc2, err := r.Counter(c2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := rc2.Get(); err != nil || v != 100 {
+		t.Fatalf("Got %d (%v), expected %d", v, err, 100)
+	}
+
+	if _, err := r.Counter(c1.ID()); err == nil {
+		t.Fatal("The freed counter must not be found")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestRotate.dat")
+	rotatedFilename := path.Join(GetMCountersDirectoryPath(), "goTestRotate.1.dat")
+	os.Remove(filename)
+	os.Remove(rotatedFilename)
+
+	w, err := NewWriterForFileWithOptions(filename, map[string]string{"app": "test", "zone": "a"}, 8,
+		WriterOptions{ValueStride: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	c1, err := w.AddCounterWithInitialValue("c1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := w.AddCounterWithInitialValue("c2", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	c3, err := w.AddFloatCounter("c3", 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Increment()
+
+	nw, counters, err := w.Rotate(rotatedFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotatedFilename)
+	defer nw.Close()
+
+	if len(counters) != 2 || counters[c1.ID()] == nil || counters[c3.ID()] == nil {
+		t.Fatalf("Got counters %v, expected c1 and c3", counters)
+	}
+	counters[c1.ID()].Increment()
+
+	r, err := NewReaderForFile(rotatedFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.FileInfo().ValueStride != 16 {
+		t.Fatalf("Value stride %d, expected 16", r.FileInfo().ValueStride)
+	}
+	if v, err := r.GetStaticValue("zone"); err != nil || v != "a" {
+		t.Fatalf("Got static %s (%v), expected a", v, err)
+	}
+
+	values := make(map[string]int64)
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		values[label] = value
+		return true
+	})
+	if len(values) != 2 || values["c1"] != 12 {
+		t.Fatalf("Got counters %v, expected c1 with 12 and c3", values)
+	}
+	if v, err := r.GetFloatCounterValue(counters[c3.ID()].ID()); err != nil || v != 1.5 {
+		t.Fatalf("Got %f (%v), expected 1.5", v, err)
+	}
+	if c1.Get() != 11 {
+		t.Fatalf("Got %d for the old counter, expected 11", c1.Get())
+	}
+
+	if _, _, err := w.Rotate(rotatedFilename); err == nil {
+		t.Fatal("Rotation into an existing file must fail")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestObserve.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	c, err := w.AddCounterWithInitialValue("observed", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	changes := make(chan [2]int64, 10)
+	stop := r.Observe(c.ID(), time.Millisecond, func(old, new int64) {
+		changes <- [2]int64{old, new}
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	c.Set(5)
+	select {
+	case change := <-changes:
+		if change != [2]int64{1, 5} {
+			t.Fatalf("Got change %v, expected [1 5]", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No change observed")
+	}
+
+	stop()
+	stop()
+	c.Set(7)
+	time.Sleep(10 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Fatalf("Got change %v after stop", <-changes)
+	}
+
+	r.Observe(c.ID(), time.Millisecond, func(old, new int64) {
+		changes <- [2]int64{old, new}
+	})
+	if err := r.Close(); err != nil { // Waits for the observer to stop polling
+		t.Fatal(err)
+	}
+	c.Set(9)
+	time.Sleep(10 * time.Millisecond)
+	if len(changes) != 0 {
+		t.Fatalf("Got change %v after close", <-changes)
+	}
+	r.Observe(c.ID(), time.Millisecond, func(old, new int64) {
+		t.Error("A closed reader must not be observed")
+	})()
+
+	// A callback may close the reader
+	r, err = NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error)
+	stop = r.Observe(c.ID(), time.Millisecond, func(old, new int64) {
+		closed <- r.Close()
+	})
+	time.Sleep(10 * time.Millisecond)
+	c.Set(11)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close called from the callback is blocked")
+	}
+	stop()
+}
+
+func TestCounterWithID(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestCounterWithID.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFileWithOptions(filename, map[string]string{}, 8, WriterOptions{ExplicitIDs: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	auto, err := w.AddCounterWithInitialValue("auto", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auto.ID() != 10 {
+		t.Fatalf("Got ID %d, expected 10 after the explicit IDs", auto.ID())
+	}
+
+	c3, err := w.AddCounterWithID(3, "three", 33)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3.ID() != 3 {
+		t.Fatalf("Got ID %d, expected 3", c3.ID())
+	}
+	if _, err := w.AddCounterWithID(0, "zero", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.AddCounterWithID(3, "three again", 0); err == nil {
+		t.Fatal("The ID of a live counter must not be allocated again")
+	}
+	for _, id := range []int64{-1, 10, 11} {
+		if _, err := w.AddCounterWithID(id, "out of range", 0); err == nil {
+			t.Fatalf("ID %d out of the explicit IDs must be rejected", id)
+		}
+	}
+
+	auto2, err := w.AddCounter("auto2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auto2.ID() != 11 {
+		t.Fatalf("Got ID %d, expected 11", auto2.ID())
+	}
+
+	c3.Close()
+	c3, err = w.AddCounterWithID(3, "three again", 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if v, err := r.GetCounterValue(3); err != nil || v != 34 {
+		t.Fatalf("Got %d (%v), expected 34", v, err)
+	}
+	if l, err := r.GetCounterLabel(3); err != nil || l != "three again" {
+		t.Fatalf("Got label %s (%v), expected 'three again'", l, err)
+	}
+	n := 0
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		n++
+		return true
+	})
+	if n != 4 {
+		t.Fatalf("%d counters iterated, expected 4", n)
+	}
+
+	c3.Close()
+	if _, err := r.GetCounterValue(3); err == nil {
+		t.Fatal("The closed counter must not be found")
+	}
+
+	if _, err := NewWriterForFileWithOptions(filename+".neg", map[string]string{}, 1, WriterOptions{ExplicitIDs: -1}); err == nil {
+		os.Remove(filename + ".neg")
+		t.Fatal("Negative number of explicit IDs must be rejected")
+	}
+}
+
+func TestReaderPool(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestReaderPool.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"static": "value"}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := int64(0); i < 4; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	pool := NewReaderPool(r)
+	expected := r.Dump()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h := pool.Get()
+				if d := h.Dump(); !reflect.DeepEqual(d, expected) {
+					errs <- fmt.Errorf("Got dump %+v, expected %+v", d, expected)
+					return
+				}
+				if h.Close() != nil {
+					errs <- errors.New("Closing of a handle must not fail")
+					return
+				}
+				pool.Put(h)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Closing of the handles doesn't unmap the file
+	if v, err := r.GetStaticValue("static"); err != nil || v != "value" {
+		t.Fatalf("Got static %s (%v), expected value", v, err)
+	}
+}
+
+func TestLabelValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestLabelValidation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := strings.Repeat("x", MaxLabelLength+1)
+
+	w, err := NewWriterForFile(path.Join(dir, "permissive.dat"), map[string]string{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.AddCounter(""); err == nil {
+		t.Fatal("An error expected for the empty label")
+	}
+	c, err := w.AddCounter(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReaderForFile(w.Filename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if label, _ := r.GetCounterLabel(c.ID()); label != long[:MaxLabelLength] {
+		t.Fatalf("Got label of length %d, expected the label truncated to %d", len(label), MaxLabelLength)
+	}
+
+	strict, err := NewWriterForFileWithOptions(path.Join(dir, "strict.dat"), map[string]string{}, 2,
+		WriterOptions{StrictLabels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strict.Close()
+
+	if _, err := strict.AddCounter(long); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Fatalf("An error expected for the long label, got %v", err)
+	}
+	if _, err := strict.AddCounter(long[:MaxLabelLength]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.AddCounter(""); err == nil {
+		t.Fatal("An error expected for the empty label")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestFingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	statics := map[string]string{"host": "node1", "app": "test"}
+	labels := []string{"cnt1", "cnt2", "cnt3"}
+
+	var counters []*Counter
+	var readers []*Reader
+	for i, filename := range []string{"a.dat", "b.dat"} {
+		w, err := NewWriterForFile(path.Join(dir, filename), statics, len(labels))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		for j := range labels {
+			label := labels[j]
+			if i == 1 { // Add the counters in the reverse order
+				label = labels[len(labels)-1-j]
+			}
+			c, err := w.AddCounterWithInitialValue(label, int64(len(label)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			counters = append(counters, c)
+		}
+		r, err := NewReaderForFile(w.Filename())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+
+	fp := readers[0].Fingerprint()
+	if fp != readers[1].Fingerprint() {
+		t.Fatalf("Got different fingerprints %x and %x of identical files", fp, readers[1].Fingerprint())
+	}
+
+	counters[len(counters)-1].Increment()
+	if fp == readers[1].Fingerprint() {
+		t.Fatal("The fingerprint expected to change after the value is changed")
+	}
+	if fp != readers[0].Fingerprint() {
+		t.Fatal("The fingerprint of the unchanged file expected to be the same")
+	}
+}
+
+func TestWriterNewReader(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestWriterNewReader.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"static": "value"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Filename() != filename || r.IsWritable() {
+		t.Fatalf("Got reader of %s (writable %v), expected read-only reader of %s", r.Filename(), r.IsWritable(), filename)
+	}
+
+	c, err := w.AddCounterWithInitialValue("cnt", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.GetCounterValue(c.ID()); err != nil || v != 5 {
+		t.Fatalf("Got value %d (%v), expected 5", v, err)
+	}
+	c.Increment()
+	if v, _ := r.GetCounterValue(c.ID()); v != 6 {
+		t.Fatalf("Got value %d, expected 6", v)
+	}
+
+	w.Close()
+	if _, err := w.NewReader(); err == nil {
+		t.Fatal("An error expected for the closed writer")
+	}
+}
+
+func TestForEachCounterLive(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestForEachCounterLive.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	labels := func() (ls []string) {
+		r.ForEachCounterLive(func(id, value int64, label string) bool {
+			ls = append(ls, label)
+			return true
+		})
+		return ls
+	}
+
+	if ls := labels(); len(ls) != 0 {
+		t.Fatalf("Got %v, expected no counters", ls)
+	}
+
+	for i, expected := range [][]string{{"cnt0"}, {"cnt0", "cnt1"}, {"cnt0", "cnt1", "cnt2"}} {
+		if _, err := w.AddCounter(fmt.Sprintf("cnt%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if ls := labels(); !reflect.DeepEqual(ls, expected) {
+			t.Fatalf("Got %v, expected %v", ls, expected)
+		}
+	}
+}
+
+func TestTypedStatics(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestTypedStatics.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"port": "8080", "offset": "-5", "enabled": "true",
+		"debug": "0", "host": "node1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for label, expected := range map[string]int64{"port": 8080, "offset": -5} {
+		if v, err := r.GetStaticInt(label); err != nil || v != expected {
+			t.Fatalf("Got %d (%v) for %s, expected %d", v, err, label, expected)
+		}
+	}
+	for label, expected := range map[string]bool{"enabled": true, "debug": false} {
+		if v, err := r.GetStaticBool(label); err != nil || v != expected {
+			t.Fatalf("Got %v (%v) for %s, expected %v", v, err, label, expected)
+		}
+	}
+
+	if _, err := r.GetStaticInt("host"); err == nil || !strings.Contains(err.Error(), "isn't an integer") {
+		t.Fatalf("Parse error expected, got %v", err)
+	}
+	if _, err := r.GetStaticBool("port"); err == nil || !strings.Contains(err.Error(), "isn't a boolean") {
+		t.Fatalf("Parse error expected, got %v", err)
+	}
+	if _, err := r.GetStaticInt("unknown"); err == nil {
+		t.Fatal("An error expected for the unknown static")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestReserve.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Version() != layout.CountersVersion {
+		t.Fatalf("Version %d, expected %d", r.Version(), layout.CountersVersion)
+	}
+	if err := w.Reserve(2); err != nil {
+		t.Fatal(err)
+	}
+	if w.Reserved() != 2 {
+		t.Fatalf("Got %d reserved slots, expected 2", w.Reserved())
+	}
+	if !r.Features().Has(FeatureReservedSlots) || r.Version() != layout.CountersVersionExtended {
+		t.Fatalf("Got features %b and version %d, expected the reserved slots and version %d",
+			r.Features(), r.Version(), layout.CountersVersionExtended)
+	}
+
+	if _, err := w.AddCounter("regular"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddCounter("extra"); err == nil {
+		t.Fatal("No free space expected, since the other slots are reserved")
+	}
+	if err := w.Reserve(1); err == nil {
+		t.Fatal("No free space expected to reserve")
+	}
+
+	count := 0
+	r.ForEachCounterLive(func(id, value int64, label string) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Fatalf("Got %d counters, the reserved slots must be skipped", count)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := w.UseReserved(fmt.Sprintf("reserved%d", i), int64(i+10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := r.GetCounterValue(c.ID()); err != nil || v != int64(i+10) {
+			t.Fatalf("Got %d (%v), expected %d", v, err, i+10)
+		}
+	}
+	if _, err := w.UseReserved("reserved2", 0); err == nil {
+		t.Fatal("No reserved slots expected to be left")
+	}
+
+	var labels []string
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		labels = append(labels, label)
+		return true
+	})
+	if !reflect.DeepEqual(labels, []string{"reserved0", "reserved1", "regular"}) {
+		t.Fatalf("Got %v, expected the reserved slots used", labels)
+	}
+}
+
+func TestRotateReserved(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestRotateReserved.dat")
+	rotatedFilename := path.Join(GetMCountersDirectoryPath(), "goTestRotateReserved.1.dat")
+	os.Remove(filename)
+	os.Remove(rotatedFilename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	if err := w.Reserve(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.UseReserved("used", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	nw, _, err := w.Rotate(rotatedFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotatedFilename)
+	defer nw.Close()
+
+	if nw.Reserved() != 2 {
+		t.Fatalf("Got %d reserved slots, expected 2", nw.Reserved())
+	}
+	if _, err := nw.AddCounter("regular"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nw.AddCounter("extra"); err == nil {
+		t.Fatal("No free space expected, since the other slots are reserved")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := nw.UseReserved(fmt.Sprintf("reserved%d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestWritePrometheus.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	if _, err := w.AddCounterWithInitialValue("requests.total", 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddCounterWithInitialValue("requests.total", 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddFloatCounter("9 load \"avg\"", 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var sb strings.Builder
+	if err := r.WritePrometheus(&sb, "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP app_9_load__avg_ mc4go float64 counter 9 load "avg"
+# TYPE app_9_load__avg_ gauge
+app_9_load__avg_{id="2",label="9 load \"avg\""} 0.5
+# HELP app_requests_total mc4go int64 counter requests.total
+# TYPE app_requests_total gauge
+app_requests_total{id="0",label="requests.total"} 42
+app_requests_total{id="1",label="requests.total"} 8
+`
+	if sb.String() != expected {
+		t.Fatalf("Got:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+
+	sb.Reset()
+	if err := r.WritePrometheus(&sb, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "\n_9_load__avg_{") {
+		t.Fatalf("Leading digit isn't sanitized: %s", sb.String())
+	}
+}
+
+func TestSnapshotCounters(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestSnapshotCounters.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	requests, err := w.AddCounter("requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := w.AddCounter("errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.SnapshotCounters([]int64{requests.ID(), 1000}); err == nil {
+		t.Fatal("Snapshot of an unknown counter succeeded")
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Every error is counted right after its request
+			requests.Increment()
+			errs.Increment()
+			runtime.Gosched()
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		values, err := r.SnapshotCounters([]int64{requests.ID(), errs.ID(), requests.ID()})
+		if err != nil {
+			if !strings.Contains(err.Error(), "kept changing") {
+				t.Fatal(err)
+			}
+			continue // Allowed while the counters are updated continuously
+		}
+		if len(values) != 2 {
+			t.Fatalf("Got %d values, expected 2", len(values))
+		}
+		rv, ev := values[requests.ID()], values[errs.ID()]
+		if rv < ev || rv > ev+1 {
+			t.Fatalf("Inconsistent snapshot: %d requests, %d errors", rv, ev)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	values, err := r.SnapshotCounters([]int64{requests.ID(), errs.ID()})
+	if err != nil {
+		t.Fatalf("Snapshot failed once the writer paused: %v", err)
+	}
+	if values[requests.ID()] != requests.Get() || values[errs.ID()] != errs.Get() ||
+		values[requests.ID()] != values[errs.ID()] {
+		t.Fatalf("Got %v, expected %d requests and errors", values, requests.Get())
+	}
+}
+
+func TestMinMaxCounter(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestMinMaxCounter.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFileWithOptions(filename, nil, 10, WriterOptions{ValueStride: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddMinMaxCounter("latency"); err == nil {
+		t.Fatal("Min/max counter added with too small value stride")
+	}
+	w.Close()
+	os.Remove(filename)
+
+	w, err = NewWriterForFileWithOptions(filename, nil, 10, WriterOptions{ValueStride: MinMaxValueStride})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	plain, err := w.AddCounterWithInitialValue("plain", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.AddMinMaxCounter("latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if !r.Features().Has(FeatureMinMaxCounters) {
+		t.Fatal("Min/max counters feature isn't set")
+	}
+	if r.Version() != layout.CountersVersionExtended {
+		t.Fatalf("Version %d, expected %d", r.Version(), layout.CountersVersionExtended)
+	}
+	if mm, err := r.GetCounterMinMax(c.ID()); err != nil || mm.Count != 0 {
+		t.Fatalf("Got %+v, %v, expected no values recorded", mm, err)
+	}
+
+	for _, v := range []int64{5, -3, 12, 7} {
+		c.Record(v)
+	}
+
+	mm, err := r.GetCounterMinMax(c.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (MinMax{Value: 7, Min: -3, Max: 12, Count: 4}); mm != expected {
+		t.Fatalf("Got %+v, expected %+v", mm, expected)
+	}
+	if v, err := r.GetCounterValue(c.ID()); err != nil || v != 7 {
+		t.Fatalf("Got value %d, %v, expected 7", v, err)
+	}
+	if v, err := r.GetCounterValue(plain.ID()); err != nil || v != 1 {
+		t.Fatalf("Got value %d, %v, expected 1", v, err)
+	}
+	if _, err := r.GetCounterMinMax(plain.ID()); err == nil {
+		t.Fatal("Min/max of a plain counter returned")
+	}
+
+	// A slot reused by a new min/max counter starts from scratch
+	c.Close()
+	c, err = w.AddMinMaxCounter("size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Record(100)
+	if mm, err := r.GetCounterMinMax(c.ID()); err != nil || mm != (MinMax{Value: 100, Min: 100, Max: 100, Count: 1}) {
+		t.Fatalf("Got %+v, %v", mm, err)
+	}
+	empty, err := w.AddMinMaxCounter("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := r.WritePrometheus(&sb, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, series := range []string{
+		fmt.Sprintf("\nsize{id=\"%d\",label=\"size\"} 100\n", c.ID()),
+		fmt.Sprintf("\nsize_min{id=\"%d\",label=\"size\"} 100\n", c.ID()),
+		fmt.Sprintf("\nsize_max{id=\"%d\",label=\"size\"} 100\n", c.ID()),
+		fmt.Sprintf("\nsize_count{id=\"%d\",label=\"size\"} 1\n", c.ID()),
+		fmt.Sprintf("\nempty_count{id=\"%d\",label=\"empty\"} 0\n", empty.ID()),
+	} {
+		if !strings.Contains(sb.String(), series) {
+			t.Fatalf("No %q in:\n%s", series, sb.String())
+		}
+	}
+	if strings.Contains(sb.String(), "empty_min") || strings.Contains(sb.String(), "empty_max") {
+		t.Fatalf("Min and max of an empty counter written:\n%s", sb.String())
+	}
+
+	rotated := path.Join(GetMCountersDirectoryPath(), "goTestMinMaxCounterRotated.dat")
+	os.Remove(rotated)
+	nw, counters, err := w.Rotate(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotated)
+	defer nw.Close()
+
+	if counters[plain.ID()].MinMax() != nil {
+		t.Fatal("Plain counter returned as a min/max one")
+	}
+	rc := counters[c.ID()].MinMax()
+	if rc == nil {
+		t.Fatal("Rotated min/max counter isn't a min/max one")
+	}
+	rc.Record(-1)
+
+	nr, err := nw.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nr.Close()
+
+	if mm, err := nr.GetCounterMinMax(rc.ID()); err != nil || mm != (MinMax{Value: -1, Min: -1, Max: 100, Count: 2}) {
+		t.Fatalf("Got %+v, %v, expected the min, the max and the count carried over", mm, err)
+	}
+	if mm, err := nr.GetCounterMinMax(counters[empty.ID()].ID()); err != nil || mm.Count != 0 {
+		t.Fatalf("Got %+v, %v, expected no values recorded", mm, err)
+	}
+}
+
+func TestWriterClock(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestWriterClock.dat")
+	rotated := path.Join(GetMCountersDirectoryPath(), "goTestWriterClockRotated.dat")
+	os.Remove(filename)
+	os.Remove(rotated)
+
+	started := time.Date(2020, time.March, 14, 15, 9, 26, 535897932, time.UTC)
+	expected := started.UnixNano() / int64(time.Millisecond)
+
+	w, err := NewWriterForFileWithOptions(filename, nil, 1, WriterOptions{
+		Clock: func() time.Time { return started },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.StartTime() != expected {
+		t.Fatalf("Got start time %d, expected %d", r.StartTime(), expected)
+	}
+
+	nw, _, err := w.Rotate(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rotated)
+	defer nw.Close()
+
+	nr, err := nw.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nr.Close()
+
+	if nr.StartTime() != expected {
+		t.Fatalf("Got start time %d of the rotated file, expected %d", nr.StartTime(), expected)
+	}
+}
+
+func TestSectionsOutOfFile(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestSectionsOutOfFile.dat")
+	malformed := path.Join(GetMCountersDirectoryPath(), "goTestSectionsOutOfFileMalformed.dat")
+	os.Remove(filename)
+	os.Remove(malformed)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := r.CopyBytes()
+	r.Close()
+
+	*(*int32)(unsafe.Pointer(&b[8])) = int32(layout.MetadataLength(100000)) // the metadata length
+	if err := ioutil.WriteFile(malformed, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(malformed)
+
+	if _, err := NewReaderForFile(malformed); err == nil || !strings.Contains(err.Error(), "too few bytes") {
+		t.Fatalf("Too few bytes error expected, got: %v", err)
+	}
+
+	*(*int32)(unsafe.Pointer(&b[8])) = -512
+	if _, err := NewReaderForBytes(b); err == nil || !strings.Contains(err.Error(), "negative length") {
+		t.Fatalf("Negative length error expected, got: %v", err)
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestEpoch.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Epoch() != 0 {
+		t.Fatalf("Got epoch %d, expected 0", r.Epoch())
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		prev := r.Epoch()
+		if e := w.BumpEpoch(); e != i {
+			t.Fatalf("Got bumped epoch %d, expected %d", e, i)
+		}
+		if r.Epoch() != i || r.Epoch() <= prev {
+			t.Fatalf("Got epoch %d after %d, expected %d", r.Epoch(), prev, i)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestFeatures.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var byteOrder FeatureFlags
+	if r.Features().Has(FeatureBigEndian) {
+		byteOrder = FeatureBigEndian
+	}
+	if r.Features() != byteOrder {
+		t.Fatalf("Got features %b, expected %b", r.Features(), byteOrder)
+	}
+
+	if _, err := w.AddFloatCounter("float", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Features().Has(FeatureFloatCounters) {
+		t.Fatalf("Got features %b, expected the float counters set", r.Features())
+	}
+
+	featuresFilename := path.Join(GetMCountersDirectoryPath(), "goTestFeatures2.dat")
+	os.Remove(featuresFilename)
+	fw, err := NewWriterForFileWithOptions(featuresFilename, map[string]string{}, 2,
+		WriterOptions{ValueStride: 16, ExplicitIDs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(featuresFilename)
+	defer fw.Close()
+
+	fr, err := NewReaderForFile(featuresFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	expected := byteOrder | FeatureValueStride | FeatureExplicitIDs
+	if fr.Features() != expected {
+		t.Fatalf("Got features %b, expected %b", fr.Features(), expected)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestNamespace.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	io := w.Namespace("io")
+	read := io.Namespace("read")
+	if read.Prefix() != "io.read" {
+		t.Fatalf("Got prefix %s, expected io.read", read.Prefix())
+	}
+
+	var counters []*Counter
+	c, err := io.AddCounter("errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters = append(counters, c)
+	c, err = read.AddCounterWithInitialValue("bytes", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters = append(counters, c)
+	c, err = read.Namespace("slow").AddCounter("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters = append(counters, c)
+	fc, err := read.AddFloatCounter("rate", 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	labels := make(map[int64]string)
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		labels[id] = label
+		return true
+	})
+
+	expected := []string{"io.errors", "io.read.bytes", "io.read.slow.ops"}
+	for i, c := range counters {
+		if c.Label() != expected[i] || labels[c.ID()] != expected[i] {
+			t.Fatalf("Got label %s (%s in the file), expected %s", c.Label(), labels[c.ID()], expected[i])
+		}
+	}
+	if fc.Label() != "io.read.rate" || labels[fc.ID()] != "io.read.rate" {
+		t.Fatalf("Got label %s (%s in the file), expected io.read.rate", fc.Label(), labels[fc.ID()])
+	}
+	if v := counters[1].Get(); v != 10 {
+		t.Fatalf("Got %d, expected 10", v)
+	}
+}
+
+func TestSumCountersMatching(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestSumCountersMatching.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for label, value := range map[string]int64{
+		"io.read":     10,
+		"io.write":    20,
+		"io.errors":   -3,
+		"net.read":    100,
+		"cpu.io.wait": 1000,
+	} {
+		if _, err := w.AddCounterWithInitialValue(label, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.AddFloatCounter("io.rate", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := w.AddCounterWithInitialValue("io.closed", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for expr, expected := range map[string][2]int64{
+		`^io\.`:   {27, 3},
+		`\.read$`: {110, 2},
+		`io`:      {1027, 4},
+		`^disk\.`: {0, 0},
+	} {
+		sum, count := r.SumCountersMatching(regexp.MustCompile(expr))
+		if sum != expected[0] || int64(count) != expected[1] {
+			t.Fatalf("Got sum %d of %d counters for %s, expected %d of %d", sum, count, expr, expected[0], expected[1])
+		}
+	}
+}
+
+func TestReaderForFileContext(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestReaderForFileContext.dat")
+	os.Remove(filename)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if r, err := NewReaderForFileContext(ctx, filename); err != context.Canceled {
+		if r != nil {
+			r.Close()
+		}
+		t.Fatalf("Got %v, expected %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := make(chan *Writer, 1)
+	time.AfterFunc(50*time.Millisecond, func() {
+		w, err := NewWriterForFile(filename, map[string]string{}, 1)
+		if err != nil {
+			t.Error(err)
+		}
+		created <- w
+	})
+
+	r, err := NewReaderForFileContext(ctx, filename)
+	if w := <-created; w != nil {
+		defer os.Remove(filename)
+		defer w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+}
+
+func TestCounterOnClose(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestCounterOnClose.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	c, err := w.AddCounter(counterPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	c.OnClose(func() {
+		if _, err := r.GetCounterValue(c.ID()); err == nil {
+			t.Error("The slot must be freed before the callback is called")
+		}
+		atomic.AddInt32(&calls, 1)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			c.Close()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	c.Close()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("The callback is called %d times, expected once", n)
+	}
+}
+
+func TestStreamCountersJSON(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestStreamCountersJSON.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var sb strings.Builder
+	if err := r.StreamCountersJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != "[]" {
+		t.Fatalf("Got %s, expected []", sb.String())
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s\"%d\"", counterPrefix, i), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.AddFloatCounter(counterPrefix+"float", 0.25); err != nil {
+		t.Fatal(err)
+	}
+
+	sb.Reset()
+	if err := r.StreamCountersJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
+	var counters []CounterValue
+	if err := json.Unmarshal([]byte(sb.String()), &counters); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", sb.String(), err)
+	}
+	if !strings.Contains(sb.String(), `"value":0.25`) {
+		t.Fatalf("Float isn't written as a float: %s", sb.String())
+	}
+	if expected := r.Dump().Counters; !reflect.DeepEqual(counters, expected) {
+		t.Fatalf("Got %v, expected %v", counters, expected)
+	}
+
+	failing := errors.New("failed")
+	if err := r.StreamCountersJSON(failingWriter{failing}); err != failing {
+		t.Fatalf("Got %v, expected %v", err, failing)
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func benchmarkCountersJSON(b *testing.B, write func(r *Reader) error) {
+	numberOfCounters := 10000
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goBenchmarkCountersJSON.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, numberOfCounters)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := 0; i < numberOfCounters; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := write(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamCountersJSON(b *testing.B) {
+	benchmarkCountersJSON(b, func(r *Reader) error {
+		return r.StreamCountersJSON(ioutil.Discard)
+	})
+}
+
+// BenchmarkDumpCountersJSON collects all the counters before encoding them for comparison
+func BenchmarkDumpCountersJSON(b *testing.B) {
+	benchmarkCountersJSON(b, func(r *Reader) error {
+		return json.NewEncoder(ioutil.Discard).Encode(r.Dump().Counters)
+	})
+}
+
+func TestGetCounterValues(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestGetCounterValues.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		c, err := w.AddCounterWithInitialValue(fmt.Sprintf("cnt%d", i), int64(i*10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, c.ID())
+		if i == 2 {
+			c.Close()
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	values, missing := r.GetCounterValues([]int64{ids[3], 100, ids[0], ids[2], ids[3], 100})
+	expected := map[int64]int64{ids[0]: 0, ids[3]: 30}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Got values %v, expected %v", values, expected)
+	}
+	if !reflect.DeepEqual(missing, []int64{100, ids[2]}) {
+		t.Fatalf("Got missing %v, expected [100 %d]", missing, ids[2])
+	}
+
+	if values, missing := r.GetCounterValues(nil); len(values) != 0 || len(missing) != 0 {
+		t.Fatalf("Got %v and %v for no ids", values, missing)
+	}
+}
+
+// benchmarkCounterValues reads 16 of 10000 counters with the read function.
+func benchmarkCounterValues(b *testing.B, read func(r *Reader, ids []int64)) {
+	numberOfCounters := 10000
+
+	filename := path.Join(GetMCountersDirectoryPath(), "goBenchmarkCounterValues.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, numberOfCounters)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	var ids []int64
+	for i := 0; i < numberOfCounters; i++ {
+		c, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if i%(numberOfCounters/16) == 0 {
+			ids = append(ids, c.ID())
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		read(r, ids)
+	}
+}
+
+func BenchmarkGetCounterValues(b *testing.B) {
+	benchmarkCounterValues(b, func(r *Reader, ids []int64) {
+		if _, missing := r.GetCounterValues(ids); len(missing) > 0 {
+			b.Fatalf("Counters %v not found", missing)
+		}
+	})
+}
+
+// BenchmarkGetCounterValueEach reads the same counters one by one for comparison
+func BenchmarkGetCounterValueEach(b *testing.B) {
+	benchmarkCounterValues(b, func(r *Reader, ids []int64) {
+		for _, id := range ids {
+			if _, err := r.GetCounterValue(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMisalignedBuffer(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestMisalignedBuffer.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"static": "value"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	if _, err := w.AddCounter(counterPrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b := r.CopyBytes()
+	shifted := make([]byte, len(b)+layout.Alignment)
+	for shift := 1; shift < layout.Alignment; shift++ {
+		misaligned := shifted[shift : shift+len(b)]
+		copy(misaligned, b)
+		if _, err := NewReaderForBytes(misaligned); err == nil || !strings.Contains(err.Error(), "isn't aligned") {
+			t.Fatalf("Misaligned buffer must be rejected, shift: %d, got: %v", shift, err)
+		}
+	}
+
+	aligned := shifted[:len(b)]
+	copy(aligned, b)
+	if _, err := NewReaderForBytes(aligned); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReaderWithBuffers(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestReaderWithBuffers.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{"static": "value"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := int64(0); i < 2; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), i+10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fr, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	fi := fr.FileInfo()
+	b := fr.CopyBytes()
+	buf := offheap.NewBufferFromSlice(b)
+
+	offset := layout.HeaderLength()
+	header := buf.Slice(0, offset)
+	statics := buf.Slice(uintptr(offset), fi.StaticsLength)
+	offset += fi.StaticsLength
+	metadata := buf.Slice(uintptr(offset), fi.MetadataLength)
+	offset += fi.MetadataLength
+	values := buf.Slice(uintptr(offset), fi.ValuesLength)
+
+	r, err := NewReaderWithBuffers(header, statics, metadata, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if v, err := r.GetStaticValue("static"); err != nil || v != "value" {
+		t.Fatalf("Got static %s (%v), expected value", v, err)
+	}
+	n := 0
+	r.ForEachCounter(func(id, value int64, label string) bool {
+		if label != fmt.Sprintf("%s%d", counterPrefix, id) || value != id+10 {
+			t.Fatalf("Got counter %d %s=%d", id, label, value)
+		}
+		n++
+		return true
+	})
+	if n != 2 {
+		t.Fatalf("%d counters iterated, expected 2", n)
+	}
+	if !reflect.DeepEqual(r.CopyBytes(), b) {
+		t.Fatal("Copied bytes don't match the ones of the file")
+	}
+
+	if _, err := NewReaderWithBuffers(offheap.NewBufferFromSlice(make([]byte, layout.HeaderLength())),
+		statics, metadata, values); err == nil {
+		t.Fatal("Uninitialized header must be rejected")
+	}
+	if _, err := NewReaderWithBuffers(buf.Slice(0, 8), statics, metadata, values); err == nil {
+		t.Fatal("Too small header must be rejected")
+	}
+}
+
+func TestForEachCounterContext(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestForEachCounterContext.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	for i := 0; i < 500; i++ {
+		if _, err := w.AddCounter(fmt.Sprintf("%s%d", counterPrefix, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
 
-func TestFull(t *testing.T) {
-	numberOfStatics := 1000
-	numberOfCounters := 1000
+	n := 0
+	if err := r.ForEachCounterContext(context.Background(), func(id, value int64, label string) bool {
+		n++
+		return true
+	}); err != nil || n != 500 {
+		t.Fatalf("All 500 counters expected, got %d (%v)", n, err)
+	}
 
-	filename := path.Join(GetMCountersDirectoryPath(), "goTestFull.dat")
-	_, err := os.Stat(filename)
-	if err == nil {
-		if os.Remove(filename) != nil {
-			t.Fatal(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n = 0
+	err = r.ForEachCounterContext(ctx, func(id, value int64, label string) bool {
+		n++
+		if n == 10 {
+			cancel()
 		}
+		return true
+	})
+	if err != context.Canceled {
+		t.Fatalf("Got error %v, expected %v", err, context.Canceled)
+	}
+	if n >= 500 {
+		t.Fatalf("The iteration should be stopped early, got %d counters", n)
 	}
+}
 
-	statics := make(map[string]string)
-	for i := 0; i < numberOfStatics; i++ {
-		statics[fmt.Sprintf("%s%d", propertyPrefix, i)] = fmt.Sprintf("%s%d", valuePrefix, i)
+func TestAutoFlush(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestAutoFlush.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, nil, 1)
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer os.Remove(filename)
+	defer w.Close()
 
-	w, err := NewWriterForFile(filename, statics, numberOfCounters)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	w.StartAutoFlush(time.Millisecond)
+	w.StartAutoFlush(time.Millisecond) // restarts
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&w.autoFlushes) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("No auto-flush happened")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.autoFlushStop != nil {
+		t.Fatal("The auto-flush should be stopped on close")
+	}
+	flushes := atomic.LoadInt64(&w.autoFlushes)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt64(&w.autoFlushes) != flushes {
+		t.Fatal("No auto-flush should happen after close")
+	}
+
+	w.StartAutoFlush(time.Millisecond)
+	w.StopAutoFlush()
+	if err := w.Flush(); err == nil {
+		t.Fatal("Flush of the closed writer must fail")
+	}
+}
+
+func TestVersionSkew(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestVersionSkew.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, nil, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,92 +2369,206 @@ func TestFull(t *testing.T) {
 	}
 	defer r.Close()
 
-	var counters []*Counter
+	b := r.CopyBytes()
+	*(*int32)(unsafe.Pointer(&b[0])) = layout.MaxCountersVersion + 1
 
-	for i := 0; i < numberOfCounters; i++ {
-		counter, err := w.AddCounter(fmt.Sprintf("%s%d", counterPrefix, i))
-		if err != nil {
-			t.Fatal(err)
-		}
-		counters = append(counters, counter)
+	_, err = NewReaderForBytes(b)
+	if err == nil || !strings.Contains(err.Error(), "newer than this reader supports") ||
+		!strings.Contains(err.Error(), fmt.Sprintf("file=%d, max=%d", layout.MaxCountersVersion+1, layout.MaxCountersVersion)) {
+		t.Fatalf("Newer file error expected, got: %v", err)
+	}
 
-		counter.Set(counter.id - 1)
-		counter.Increment()
+	b = r.CopyBytes()
+	*(*int32)(unsafe.Pointer(&b[36])) |= int32(layout.KnownFeatures + 1) // the features
+
+	_, err = NewReaderForBytes(b)
+	if err == nil || !strings.Contains(err.Error(), "unknown features") {
+		t.Fatalf("Unknown features error expected, got: %v", err)
 	}
 
-	numOfStaticsCounted := 0
+	err = checkVersion(1, 2, 3)
+	if err == nil || !strings.Contains(err.Error(), "older than this reader supports (file=1, min=2)") {
+		t.Fatalf("Older file error expected, got: %v", err)
+	}
 
-	r.ForEachStatic(func(label, value string) bool {
-		staticLabel := fmt.Sprintf("%s%d", propertyPrefix, numOfStaticsCounted)
+	if err := checkVersion(2, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+}
 
-		expectedValue := fmt.Sprintf("%s%d", valuePrefix, numOfStaticsCounted)
-		readValue := statics[staticLabel]
+func TestForEachStaticErr(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestForEachStaticErr.dat")
+	os.Remove(filename)
 
-		if readValue != expectedValue {
-			t.Fatalf("Read static value %s, expected %s", readValue, expectedValue)
-		}
+	statics := map[string]string{"static1": "value1", "static2": "value2", "static3": "value3", "static4": "value4"}
 
-		gotValue, err := r.GetStaticValue(staticLabel)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if readValue != expectedValue {
-			t.Fatalf("Got static value %s, expected %s", gotValue, expectedValue)
-		}
+	w, err := NewWriterForFile(filename, statics, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
 
-		delete(statics, staticLabel)
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
 
-		numOfStaticsCounted++
+	n := 0
+	if err := r.ForEachStaticErr(func(label, value string) error {
+		n++
+		return nil
+	}); err != nil || n != len(statics) {
+		t.Fatalf("All %d statics expected, got %d (%v)", len(statics), n, err)
+	}
 
-		return true
+	errThird := errors.New("third")
+	n = 0
+	err = r.ForEachStaticErr(func(label, value string) error {
+		n++
+		if n == 3 {
+			return errThird
+		}
+		return nil
 	})
-	if numOfStaticsCounted != numberOfStatics {
-		t.Fatalf("Statics counted %d, expected %d", numOfStaticsCounted, numberOfStatics)
+	if err != errThird {
+		t.Fatalf("Got error %v, expected %v", err, errThird)
 	}
-	if len(statics) != 0 {
-		t.Fatal("All statics must be removed")
+	if n != 3 {
+		t.Fatalf("The iteration should be stopped at the third static, got %d", n)
 	}
+}
 
-	numOfCountersCounted := 0
+func TestExportImportJSON(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestExportImportJSON.dat")
+	os.Remove(filename)
 
-	r.ForEachCounter(func(id, value int64, label string) bool {
-		counterLabel := fmt.Sprintf("%s%d", counterPrefix, value)
+	statics := map[string]string{"static1": "value1", "static2": "value2"}
 
-		if label != counterLabel {
-			t.Fatalf("Got counter label %s, expected %s", label, counterLabel)
-		}
+	w, err := NewWriterForFile(filename, statics, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
 
-		foundLabel, err := r.GetCounterLabel(id)
-		if err != nil {
+	for i := 0; i < 5; i++ {
+		if _, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i*100)); err != nil {
 			t.Fatal(err)
 		}
+	}
+	c, err := w.AddCounter(counterPrefix + "freed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
 
-		if foundLabel != label {
-			t.Fatalf("Found counter label %s, expected %s", foundLabel, label)
-		}
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
 
-		numOfCountersCounted++
+	var sb strings.Builder
+	if err := r.ExportJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
 
-		return true
-	})
-	if numOfCountersCounted != numberOfCounters {
-		t.Fatalf("Counters counted %d, expected %d", numOfCountersCounted, numberOfCounters)
+	ir, err := NewReaderFromJSON(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer ir.Close()
 
-	for _, c := range counters {
-		if c.IsClosed() {
-			t.Error("The counter must not be closed")
-		}
-		c.Close()
-		if !c.IsClosed() {
-			t.Fatal("The counter must be closed")
-		}
+	if ir.IsWritable() {
+		t.Fatal("The imported reader must be read-only")
+	}
+	if !reflect.DeepEqual(ir.Dump(), r.Dump()) {
+		t.Fatalf("Got %+v, expected %+v", ir.Dump(), r.Dump())
+	}
+	if len(ir.Dump().Counters) != 5 {
+		t.Fatalf("Got %d counters, expected %d", len(ir.Dump().Counters), 5)
+	}
+	if v, err := ir.GetStaticValue("static2"); err != nil || v != "value2" {
+		t.Fatalf("Got static %s (%v), expected %s", v, err, "value2")
 	}
 
-	r.ForEachCounter(func(id, value int64, label string) bool {
-		t.Error("No counters must be available")
-		return true
-	})
+	if _, err := NewReaderFromJSON(strings.NewReader("{")); err == nil {
+		t.Fatal("Invalid JSON must be rejected")
+	}
+}
+
+func TestExportImportTypedJSON(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestExportImportTypedJSON.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFileWithOptions(filename, map[string]string{"static": "value"}, 10,
+		WriterOptions{ValueStride: MinMaxValueStride})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	fc, err := w.AddFloatCounter("float", 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc, err := w.AddMinMaxCounter("minmax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int64{4, -2, 9} {
+		mc.Record(v)
+	}
+	empty, err := w.AddMinMaxCounter("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.BumpEpoch()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var sb strings.Builder
+	if err := r.ExportJSON(&sb); err != nil {
+		t.Fatal(err)
+	}
+	ir, err := NewReaderFromJSON(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+
+	if !reflect.DeepEqual(ir.Dump(), r.Dump()) {
+		t.Fatalf("Got %+v, expected %+v", ir.Dump(), r.Dump())
+	}
+	if v, err := ir.GetFloatCounterValue(fc.ID()); err != nil || v != 2.5 {
+		t.Fatalf("Got %f (%v), expected 2.5", v, err)
+	}
+	if mm, err := ir.GetCounterMinMax(mc.ID()); err != nil || mm != (MinMax{Value: 9, Min: -2, Max: 9, Count: 3}) {
+		t.Fatalf("Got %+v (%v)", mm, err)
+	}
+	if mm, err := ir.GetCounterMinMax(empty.ID()); err != nil || mm.Count != 0 {
+		t.Fatalf("Got %+v (%v), expected no values recorded", mm, err)
+	}
+	if ir.FileInfo().ValueStride != MinMaxValueStride || ir.Epoch() != 1 {
+		t.Fatalf("Got stride %d and epoch %d, expected %d and 1", ir.FileInfo().ValueStride, ir.Epoch(), MinMaxValueStride)
+	}
+
+	ir, err = NewReaderFromJSON(strings.NewReader(
+		`{"version":1,"statics":[{"label":"b","value":"2"},{"label":"a","value":"1"}],"counters":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if statics := ir.Dump().Statics; !reflect.DeepEqual(statics, []Static{{"b", "2"}, {"a", "1"}}) {
+		t.Fatalf("Got statics %v, expected the original order", statics)
+	}
 }
 
 func TestConcurrentCountersModification(t *testing.T) {
@@ -270,6 +2706,59 @@ func TestConcurrentCountersAddClose(t *testing.T) {
 	wg.Wait()
 }
 
+func TestConsistentCounterSnapshot(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestConsistentCounterSnapshot.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, map[string]string{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var done int32
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	// Slots are freed and reused by counters with the labels matching their values
+	go func() {
+		defer wg.Done()
+		for i := 0; atomic.LoadInt32(&done) == 0; i++ {
+			cnt, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			cnt.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		r.ForEachCounter(func(id, value int64, label string) bool {
+			if expected := fmt.Sprintf("%s%d", counterPrefix, value); label != expected {
+				t.Errorf("Got label %s with value %d", label, value)
+				return false
+			}
+			return true
+		})
+		if t.Failed() {
+			break
+		}
+		runtime.Gosched()
+	}
+
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+}
+
 func addAndCloseCounter(t *testing.T, w *Writer, r *Reader, i int) {
 	cnt, err := w.AddCounterWithInitialValue(fmt.Sprintf("%s%d", counterPrefix, i), int64(i))
 	if err != nil {
@@ -304,3 +2793,65 @@ func addAndCloseCounter(t *testing.T, w *Writer, r *Reader, i int) {
 		t.Error("Fail")
 	}
 }
+
+func TestRepeatedTruncatedLabel(t *testing.T) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goTestRepeatedTruncatedLabel.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	r, err := NewReaderForFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	label := strings.Repeat("0123456789", 50)
+	expectedLabel := label[:380]
+
+	for i := 0; i < 3; i++ {
+		cnt, err := w.AddCounter(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readLabel, err := r.GetCounterLabel(cnt.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readLabel != expectedLabel {
+			t.Fatalf("Iteration %d. Got counter label %s, expected %s", i, readLabel, expectedLabel)
+		}
+
+		cnt.Close()
+	}
+}
+
+func BenchmarkAddCounterRepeatedLabel(b *testing.B) {
+	filename := path.Join(GetMCountersDirectoryPath(), "goBenchmarkAddCounterRepeatedLabel.dat")
+	os.Remove(filename)
+
+	w, err := NewWriterForFile(filename, nil, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(filename)
+	defer w.Close()
+
+	label := strings.Repeat(counterPrefix, 30)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cnt, err := w.AddCounter(label)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cnt.Close()
+	}
+}